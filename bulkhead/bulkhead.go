@@ -0,0 +1,205 @@
+package bulkhead
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	ec "patterns/error_classification"
+
+	"go.uber.org/atomic"
+)
+
+// Zen: A semaphore bounds the goroutines of a whole pool, yet when one dependency slows down,
+// the calls to it hold on to every slot and calls to healthy dependencies starve behind them.
+// Like the watertight compartments of a ship, a bulkhead splits the concurrency into named
+// compartments, each with its own limit and its own short queue, so that a flood in one of them
+// stays there. Calls that find their compartment full are rejected right away instead of piling up.
+
+// ErrUnknownCompartment is returned for calls to a compartment the bulkhead doesn't have
+var ErrUnknownCompartment = errors.New("unknown compartment")
+
+// ErrRejected is matched by every RejectedError, see errors.Is
+var ErrRejected = errors.New("rejected by bulkhead")
+
+// Reason tells why a call was rejected
+type Reason int
+
+const (
+	// QueueFull is a call that found every slot taken and the queue full
+	QueueFull Reason = iota
+	// WaitCancelled is a call whose context was done while it was queued
+	WaitCancelled
+)
+
+func (r Reason) String() string {
+	switch r {
+	case QueueFull:
+		return "queue full"
+	case WaitCancelled:
+		return "wait cancelled"
+	}
+	return "unknown"
+}
+
+// RejectedError is returned for calls that a compartment didn't let through
+type RejectedError struct {
+	Compartment string
+	Reason      Reason
+	// Err is the error of the context for calls whose wait was cancelled
+	Err error
+}
+
+func (err *RejectedError) Error() string {
+	if err.Err != nil {
+		return fmt.Sprintf("rejected by bulkhead compartment %q: %v: %v", err.Compartment, err.Reason, err.Err)
+	}
+	return fmt.Sprintf("rejected by bulkhead compartment %q: %v", err.Compartment, err.Reason)
+}
+
+func (err *RejectedError) Unwrap() error {
+	return err.Err
+}
+
+// Is matches ErrRejected
+func (err *RejectedError) Is(target error) bool {
+	return target == ErrRejected
+}
+
+// Class tells a full compartment apart from a caller that gave up, see error_classification
+func (err *RejectedError) Class() ec.Class {
+	if err.Reason == QueueFull {
+		return ec.Transient
+	}
+	return ec.Classify(err.Err)
+}
+
+// Compartment configures a compartment of the bulkhead
+type Compartment struct {
+	Name string
+	// Limit is the number of calls that run at once
+	Limit int
+	// Queue is the number of calls that wait for a slot, beyond which calls are rejected
+	Queue int
+}
+
+// Stats are the counters of a compartment
+type Stats struct {
+	Limit     int
+	QueueSize int
+	// Active is the number of calls running
+	Active int64
+	// Queued is the number of calls waiting for a slot
+	Queued int64
+	// Completed is the number of calls that ran, whatever they returned
+	Completed int64
+	// Rejected is the number of calls that were turned down
+	Rejected int64
+}
+
+type compartment struct {
+	Compartment
+	// slots is the barrier letting no more than Limit calls run at any given instant
+	slots     chan struct{}
+	active    atomic.Int64
+	queued    atomic.Int64
+	completed atomic.Int64
+	rejected  atomic.Int64
+}
+
+// Bulkhead splits concurrency into compartments. It is safe for concurrent use.
+type Bulkhead struct {
+	compartments map[string]*compartment
+}
+
+// New returns a bulkhead with the given compartments, whose names must be unique and limits positive
+func New(compartments ...Compartment) (*Bulkhead, error) {
+	b := &Bulkhead{compartments: make(map[string]*compartment, len(compartments))}
+	for _, c := range compartments {
+		if c.Limit <= 0 || c.Queue < 0 {
+			return nil, fmt.Errorf("compartment %q: limit must be positive and queue non negative, got %d and %d", c.Name, c.Limit, c.Queue)
+		}
+		if _, ok := b.compartments[c.Name]; ok {
+			return nil, fmt.Errorf("compartment %q: declared twice", c.Name)
+		}
+		b.compartments[c.Name] = &compartment{Compartment: c, slots: make(chan struct{}, c.Limit)}
+	}
+	return b, nil
+}
+
+// Execute runs fn in the named compartment once a slot is free. A call that finds the queue
+// full, or whose context is done while it waits, is rejected with a *RejectedError.
+func (b *Bulkhead) Execute(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	_, err := Do(ctx, b, name, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
+
+// Do is Execute for calls that return a value
+func Do[T any](ctx context.Context, b *Bulkhead, name string, fn func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	c, ok := b.compartments[name]
+	if !ok {
+		return zero, fmt.Errorf("%w: %q", ErrUnknownCompartment, name)
+	}
+	if err := c.acquire(ctx); err != nil {
+		return zero, err
+	}
+	defer c.release()
+	return fn(ctx)
+}
+
+// Stats returns the counters of the named compartment
+func (b *Bulkhead) Stats(name string) (Stats, bool) {
+	c, ok := b.compartments[name]
+	if !ok {
+		return Stats{}, false
+	}
+	return Stats{
+		Limit:     c.Limit,
+		QueueSize: c.Queue,
+		Active:    c.active.Load(),
+		Queued:    c.queued.Load(),
+		Completed: c.completed.Load(),
+		Rejected:  c.rejected.Load(),
+	}, true
+}
+
+// AllStats returns the counters of every compartment by name
+func (b *Bulkhead) AllStats() map[string]Stats {
+	stats := make(map[string]Stats, len(b.compartments))
+	for name := range b.compartments {
+		stats[name], _ = b.Stats(name)
+	}
+	return stats
+}
+
+func (c *compartment) acquire(ctx context.Context) error {
+	// take a free slot right away if there is one, the queue is only for when there's none
+	select {
+	case c.slots <- struct{}{}:
+		c.active.Inc()
+		return nil
+	default:
+	}
+	if c.queued.Inc() > int64(c.Queue) {
+		c.queued.Dec()
+		c.rejected.Inc()
+		return &RejectedError{Compartment: c.Name, Reason: QueueFull}
+	}
+	defer c.queued.Dec()
+	select {
+	case c.slots <- struct{}{}:
+		c.active.Inc()
+		return nil
+	case <-ctx.Done():
+		c.rejected.Inc()
+		return &RejectedError{Compartment: c.Name, Reason: WaitCancelled, Err: ctx.Err()}
+	}
+}
+
+func (c *compartment) release() {
+	c.active.Dec()
+	c.completed.Inc()
+	<-c.slots
+}
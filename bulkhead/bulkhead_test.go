@@ -0,0 +1,109 @@
+package bulkhead
+
+import (
+	"context"
+	"errors"
+	ec "patterns/error_classification"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+// blocked starts calls in the compartment that hold on to their slot until released, and
+// waits for them to be running or queued
+func blocked(t *testing.T, b *Bulkhead, name string, calls int, release <-chan struct{}) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	wg.Add(calls)
+	for i := 0; i < calls; i++ {
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, b.Execute(context.Background(), name, func(context.Context) error {
+				<-release
+				return nil
+			}))
+		}()
+	}
+	assert.Eventually(t, func() bool {
+		s, _ := b.Stats(name)
+		return s.Active+s.Queued == int64(calls)
+	}, time.Second, time.Millisecond)
+	return &wg
+}
+
+func TestBulkheadIsolatesCompartments(t *testing.T) {
+	b, err := New(Compartment{Name: "payments", Limit: 2, Queue: 1}, Compartment{Name: "search", Limit: 1})
+	assert.NoError(t, err)
+
+	// payments is flooded: both slots are taken and the queue is full
+	release := make(chan struct{})
+	wg := blocked(t, b, "payments", 3, release)
+
+	err = b.Execute(context.Background(), "payments", func(context.Context) error { return nil })
+	var rejected *RejectedError
+	assert.ErrorAs(t, err, &rejected)
+	assert.Equal(t, &RejectedError{Compartment: "payments", Reason: QueueFull}, rejected)
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.Equal(t, ec.Transient, ec.Classify(err))
+
+	// yet search is unaffected
+	assert.NoError(t, b.Execute(context.Background(), "search", func(context.Context) error { return nil }))
+
+	assert.Equal(t, map[string]Stats{
+		"payments": {Limit: 2, QueueSize: 1, Active: 2, Queued: 1, Rejected: 1},
+		"search":   {Limit: 1, Completed: 1},
+	}, b.AllStats())
+
+	close(release)
+	wg.Wait()
+	stats, ok := b.Stats("payments")
+	assert.True(t, ok)
+	assert.Equal(t, Stats{Limit: 2, QueueSize: 1, Completed: 3, Rejected: 1}, stats)
+}
+
+func TestBulkheadWaitCancelled(t *testing.T) {
+	b, err := New(Compartment{Name: "search", Limit: 1, Queue: 1})
+	assert.NoError(t, err)
+	release := make(chan struct{})
+	wg := blocked(t, b, "search", 1, release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = b.Execute(ctx, "search", func(context.Context) error { return nil })
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, ec.Timeout, ec.Classify(err))
+	assert.EqualError(t, err, `rejected by bulkhead compartment "search": wait cancelled: context deadline exceeded`)
+
+	close(release)
+	wg.Wait()
+}
+
+func TestBulkheadReturnsWhatTheCallReturns(t *testing.T) {
+	b, err := New(Compartment{Name: "search", Limit: 1})
+	assert.NoError(t, err)
+
+	v, err := Do(context.Background(), b, "search", func(context.Context) (int, error) { return 42, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+
+	errDown := errors.New("down")
+	assert.ErrorIs(t, b.Execute(context.Background(), "search", func(context.Context) error { return errDown }), errDown)
+
+	assert.ErrorIs(t, b.Execute(context.Background(), "inventory", func(context.Context) error { return nil }), ErrUnknownCompartment)
+	_, ok := b.Stats("inventory")
+	assert.False(t, ok)
+}
+
+func TestNewValidatesCompartments(t *testing.T) {
+	_, err := New(Compartment{Name: "search", Limit: 0})
+	assert.EqualError(t, err, `compartment "search": limit must be positive and queue non negative, got 0 and 0`)
+	_, err = New(Compartment{Name: "search", Limit: 1}, Compartment{Name: "search", Limit: 2})
+	assert.EqualError(t, err, `compartment "search": declared twice`)
+}
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
@@ -0,0 +1,333 @@
+package circuit_breaker
+
+import (
+	"context"
+	"errors"
+	"patterns/clock"
+	ec "patterns/error_classification"
+	"sync"
+	"time"
+)
+
+// Zen: A dependency that is down doesn't need more calls, it needs fewer. Retries and timeouts
+// alone keep every caller waiting on it and every goroutine piling up behind them. A breaker
+// watches the outcome of the calls, and once too many fail it opens: calls fail right away
+// without reaching the dependency. After a while it lets a few probes through, half-open, and
+// closes again only if they succeed, so that a recovering dependency isn't knocked over again.
+
+// State is the state of a breaker
+type State int
+
+const (
+	// Closed lets every call through, and counts the failures
+	Closed State = iota
+	// Open fails every call right away
+	Open
+	// HalfOpen lets a limited number of probes through to decide whether to close or open again
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+var (
+	// ErrOpen is returned for calls refused by an open breaker. It carries a hint of when the
+	// breaker will let probes through, see error_classification.RetryAfter.
+	ErrOpen = errors.New("circuit breaker is open")
+	// ErrTooManyProbes is returned for calls refused by a half-open breaker whose probes are all
+	// in flight
+	ErrTooManyProbes = errors.New("circuit breaker is half-open and has enough probes in flight")
+)
+
+const (
+	// DefaultWindow is the length of the rolling window when none is configured
+	DefaultWindow = 10 * time.Second
+	// DefaultBuckets is the number of buckets in the rolling window when none is configured
+	DefaultBuckets = 10
+	// DefaultOpenTimeout is the time a breaker stays open when none is configured
+	DefaultOpenTimeout = 5 * time.Second
+)
+
+// Options configure a breaker. At least one of ConsecutiveFailures or FailureRatio should be set,
+// otherwise the breaker never trips.
+type Options struct {
+	// ConsecutiveFailures trips the breaker after that many failures in a row
+	ConsecutiveFailures int
+	// FailureRatio trips the breaker once the failures over the rolling window reach that ratio
+	// of the calls, as long as there have been at least MinRequests of them
+	FailureRatio float64
+	// MinRequests is the number of calls in the window below which the ratio isn't considered
+	MinRequests int
+	// Window is the length of the rolling window the ratio is computed over
+	Window time.Duration
+	// Buckets is the number of buckets the window is split into, calls expire one bucket at a time
+	Buckets int
+	// OpenTimeout is how long the breaker stays open before letting probes through
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of probes let through at once when half-open, all of which
+	// must succeed for the breaker to close. Defaults to 1.
+	HalfOpenProbes int
+	// IsFailure decides which errors count as failures, defaults to every error but
+	// context.Canceled, since a caller giving up says nothing about the dependency
+	IsFailure func(error) bool
+	// OnStateChange is called on every change of state, outside of the breaker's lock
+	OnStateChange func(from, to State)
+	// Clock is the source of time, defaults to the wall clock
+	Clock clock.Clock
+}
+
+// Counts are the calls seen over the rolling window
+type Counts struct {
+	Requests            int
+	Failures            int
+	ConsecutiveFailures int
+}
+
+type bucket struct {
+	epoch    int64
+	requests int
+	failures int
+}
+
+// Breaker is a circuit breaker. It is safe for concurrent use.
+type Breaker struct {
+	opts       Options
+	clk        clock.Clock
+	bucketSize time.Duration
+
+	mu          sync.Mutex
+	state       State
+	generation  uint64
+	buckets     []bucket
+	consecutive int
+	openedAt    time.Time
+	probes      int
+	successes   int
+	// transitions are the state changes left to report once the lock is released
+	transitions [][2]State
+}
+
+// New returns a closed breaker
+func New(opts Options) *Breaker {
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+	if opts.Buckets <= 0 {
+		opts.Buckets = DefaultBuckets
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = DefaultOpenTimeout
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = 1
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = func(err error) bool {
+			return err != nil && !errors.Is(err, context.Canceled)
+		}
+	}
+	clk := opts.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
+	return &Breaker{
+		opts:       opts,
+		clk:        clk,
+		bucketSize: opts.Window / time.Duration(opts.Buckets),
+		buckets:    make([]bucket, opts.Buckets),
+	}
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	state := b.currentState()
+	b.unlock()
+	return state
+}
+
+// Counts returns the calls seen over the rolling window
+func (b *Breaker) Counts() Counts {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.windowCounts()
+}
+
+// Execute calls fn if the breaker lets it through, and records its outcome. A refused call
+// returns ErrOpen or ErrTooManyProbes without calling fn.
+func (b *Breaker) Execute(ctx context.Context, fn func(ctx context.Context) error) error {
+	_, err := Do(ctx, b, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
+
+// Do is Execute for calls that return a value. A call that panics is recorded as a failure
+// before the panic carries on up the stack.
+func Do[T any](ctx context.Context, b *Breaker, fn func(ctx context.Context) (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	generation, err := b.allow()
+	if err != nil {
+		return zero, err
+	}
+	// a call that panics is a failure too, and must give back its half-open probe
+	panicked := true
+	defer func() {
+		if panicked {
+			b.count(generation, true, false)
+		}
+	}()
+	v, err := fn(ctx)
+	panicked = false
+	b.record(generation, err)
+	return v, err
+}
+
+// allow decides whether a call goes through, and returns the generation it belongs to
+func (b *Breaker) allow() (uint64, error) {
+	b.mu.Lock()
+	defer b.unlock()
+	switch b.currentState() {
+	case Open:
+		remaining := b.opts.OpenTimeout - b.clk.Since(b.openedAt)
+		return 0, ec.WithRetryAfter(ErrOpen, remaining)
+	case HalfOpen:
+		if b.probes >= b.opts.HalfOpenProbes {
+			return 0, ec.Tag(ErrTooManyProbes, ec.Transient)
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+// record counts the outcome of a call, unless the breaker changed state while it was running
+func (b *Breaker) record(generation uint64, err error) {
+	failed := b.opts.IsFailure(err)
+	// an error that isn't a failure, such as a caller giving up, is neither counted as a
+	// success nor as a failure
+	b.count(generation, failed, err != nil && !failed)
+}
+
+func (b *Breaker) count(generation uint64, failed, neutral bool) {
+	b.mu.Lock()
+	defer b.unlock()
+	if generation != b.generation {
+		return
+	}
+	if b.state == HalfOpen {
+		b.probes--
+		if neutral {
+			return
+		}
+		if failed {
+			b.setState(Open)
+			return
+		}
+		b.successes++
+		if b.successes >= b.opts.HalfOpenProbes {
+			b.setState(Closed)
+		}
+		return
+	}
+	if neutral {
+		return
+	}
+
+	bk := b.bucket()
+	bk.requests++
+	if !failed {
+		b.consecutive = 0
+		return
+	}
+	bk.failures++
+	b.consecutive++
+	if b.opts.ConsecutiveFailures > 0 && b.consecutive >= b.opts.ConsecutiveFailures {
+		b.setState(Open)
+		return
+	}
+	if b.opts.FailureRatio > 0 {
+		counts := b.windowCounts()
+		if counts.Requests >= b.opts.MinRequests && float64(counts.Failures) >= b.opts.FailureRatio*float64(counts.Requests) {
+			b.setState(Open)
+		}
+	}
+}
+
+// currentState moves an open breaker to half-open once its timeout has elapsed
+func (b *Breaker) currentState() State {
+	if b.state == Open && b.clk.Since(b.openedAt) >= b.opts.OpenTimeout {
+		b.setState(HalfOpen)
+	}
+	return b.state
+}
+
+// setState starts a new generation in the given state, so that calls from the previous one
+// aren't counted in it
+func (b *Breaker) setState(to State) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.probes, b.successes = 0, 0
+	switch to {
+	case Open:
+		b.openedAt = b.clk.Now()
+	case Closed:
+		b.consecutive = 0
+		for i := range b.buckets {
+			b.buckets[i] = bucket{}
+		}
+	}
+	b.transitions = append(b.transitions, [2]State{from, to})
+}
+
+// unlock releases the lock and then reports the state changes made while it was held, so that
+// callbacks are free to call the breaker
+func (b *Breaker) unlock() {
+	transitions := b.transitions
+	b.transitions = nil
+	b.mu.Unlock()
+	if b.opts.OnStateChange == nil {
+		return
+	}
+	for _, t := range transitions {
+		b.opts.OnStateChange(t[0], t[1])
+	}
+}
+
+func (b *Breaker) epoch() int64 {
+	return b.clk.Now().UnixNano() / int64(b.bucketSize)
+}
+
+// bucket returns the bucket of the current time, emptied if it was left over from a past window
+func (b *Breaker) bucket() *bucket {
+	epoch := b.epoch()
+	bk := &b.buckets[epoch%int64(len(b.buckets))]
+	if bk.epoch != epoch {
+		*bk = bucket{epoch: epoch}
+	}
+	return bk
+}
+
+func (b *Breaker) windowCounts() Counts {
+	counts := Counts{ConsecutiveFailures: b.consecutive}
+	now := b.epoch()
+	for _, bk := range b.buckets {
+		if now-bk.epoch < int64(len(b.buckets)) {
+			counts.Requests += bk.requests
+			counts.Failures += bk.failures
+		}
+	}
+	return counts
+}
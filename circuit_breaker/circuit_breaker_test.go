@@ -0,0 +1,207 @@
+package circuit_breaker
+
+import (
+	"context"
+	"errors"
+	"patterns/clock"
+	ec "patterns/error_classification"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+var errDown = errors.New("down")
+
+func succeed(context.Context) error { return nil }
+func fail(context.Context) error    { return errDown }
+
+// transitions records the state changes of a breaker
+type transitions struct {
+	mu     sync.Mutex
+	states []string
+}
+
+func (tr *transitions) record(from, to State) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.states = append(tr.states, from.String()+"->"+to.String())
+}
+
+func (tr *transitions) get() []string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return append([]string(nil), tr.states...)
+}
+
+func TestBreakerTripsOnConsecutiveFailures(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	var tr transitions
+	b := New(Options{ConsecutiveFailures: 3, OpenTimeout: time.Second, OnStateChange: tr.record, Clock: clk})
+	ctx := context.Background()
+
+	assert.ErrorIs(t, b.Execute(ctx, fail), errDown)
+	assert.ErrorIs(t, b.Execute(ctx, fail), errDown)
+	// a success in between resets the streak
+	assert.NoError(t, b.Execute(ctx, succeed))
+	assert.ErrorIs(t, b.Execute(ctx, fail), errDown)
+	assert.ErrorIs(t, b.Execute(ctx, fail), errDown)
+	assert.Equal(t, Closed, b.State())
+	assert.ErrorIs(t, b.Execute(ctx, fail), errDown)
+	assert.Equal(t, Open, b.State())
+
+	called := false
+	err := b.Execute(ctx, func(context.Context) error {
+		called = true
+		return nil
+	})
+	assert.False(t, called)
+	assert.ErrorIs(t, err, ErrOpen)
+	after, ok := ec.RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, after)
+	assert.Equal(t, []string{"closed->open"}, tr.get())
+}
+
+func TestBreakerTripsOnFailureRatio(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	b := New(Options{FailureRatio: 0.5, MinRequests: 4, Window: 10 * time.Second, Buckets: 10, Clock: clk})
+	ctx := context.Background()
+
+	// failures that have rolled out of the window don't count anymore
+	_ = b.Execute(ctx, fail)
+	_ = b.Execute(ctx, fail)
+	_ = b.Execute(ctx, succeed)
+	clk.Advance(10 * time.Second)
+	assert.Equal(t, Counts{}, b.Counts())
+
+	_ = b.Execute(ctx, succeed)
+	_ = b.Execute(ctx, fail)
+	_ = b.Execute(ctx, succeed)
+	assert.Equal(t, Counts{Requests: 3, Failures: 1, ConsecutiveFailures: 0}, b.Counts())
+	assert.Equal(t, Closed, b.State())
+	clk.Advance(5 * time.Second)
+	_ = b.Execute(ctx, fail)
+	assert.Equal(t, Open, b.State())
+}
+
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	var tr transitions
+	b := New(Options{ConsecutiveFailures: 1, OpenTimeout: time.Second, HalfOpenProbes: 2, OnStateChange: tr.record, Clock: clk})
+	ctx := context.Background()
+
+	_ = b.Execute(ctx, fail)
+	clk.Advance(time.Second)
+	assert.Equal(t, HalfOpen, b.State())
+
+	// two probes are let through at once, and no more
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	started := make(chan struct{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, b.Execute(ctx, func(context.Context) error {
+				started <- struct{}{}
+				<-release
+				return nil
+			}))
+		}()
+	}
+	<-started
+	<-started
+	assert.ErrorIs(t, b.Execute(ctx, succeed), ErrTooManyProbes)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, Closed, b.State())
+	assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->closed"}, tr.get())
+}
+
+func TestBreakerReopensOnFailedProbe(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	var tr transitions
+	b := New(Options{ConsecutiveFailures: 1, OpenTimeout: time.Second, OnStateChange: tr.record, Clock: clk})
+	ctx := context.Background()
+
+	_ = b.Execute(ctx, fail)
+	clk.Advance(time.Second)
+	assert.ErrorIs(t, b.Execute(ctx, fail), errDown)
+	assert.Equal(t, Open, b.State())
+	assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->open"}, tr.get())
+}
+
+func TestBreakerCountsPanicAsFailedProbe(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	b := New(Options{ConsecutiveFailures: 1, OpenTimeout: time.Second, Clock: clk})
+	ctx := context.Background()
+
+	_ = b.Execute(ctx, fail)
+	clk.Advance(time.Second)
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = b.Execute(ctx, func(context.Context) error { panic("boom") })
+	})
+	// the panic reopened the breaker, rather than holding on to the only probe forever
+	assert.Equal(t, Open, b.State())
+	clk.Advance(time.Second)
+	assert.NoError(t, b.Execute(ctx, succeed))
+	assert.Equal(t, Closed, b.State())
+}
+
+func TestBreakerIgnoresCallerGivingUp(t *testing.T) {
+	b := New(Options{ConsecutiveFailures: 1, Clock: clock.NewVirtual(time.Unix(0, 0))})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	err := b.Execute(ctx, func(ctx context.Context) error {
+		cancel()
+		return ctx.Err()
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, Closed, b.State())
+	assert.Equal(t, Counts{}, b.Counts())
+	// a call made with a context that is already done never reaches fn
+	assert.ErrorIs(t, b.Execute(ctx, succeed), context.Canceled)
+}
+
+func TestBreakerIgnoresOutcomesFromAPreviousState(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	b := New(Options{ConsecutiveFailures: 1, OpenTimeout: time.Second, Clock: clk})
+	ctx := context.Background()
+
+	// a slow call started while closed, which fails once the breaker is already half-open
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := make(chan error)
+	go func() {
+		slow <- b.Execute(ctx, func(context.Context) error {
+			close(started)
+			<-release
+			return errDown
+		})
+	}()
+	<-started
+	_ = b.Execute(ctx, fail)
+	clk.Advance(time.Second)
+	assert.Equal(t, HalfOpen, b.State())
+	close(release)
+	assert.ErrorIs(t, <-slow, errDown)
+
+	// the stale failure neither reopened the breaker nor used up its probe
+	assert.Equal(t, HalfOpen, b.State())
+	assert.NoError(t, b.Execute(ctx, succeed))
+	assert.Equal(t, Closed, b.State())
+}
+
+func TestDo(t *testing.T) {
+	b := New(Options{ConsecutiveFailures: 1})
+	v, err := Do(context.Background(), b, func(context.Context) (int, error) { return 42, nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 42, v)
+}
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
@@ -0,0 +1,218 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Zen: Concurrent code that reads the wall clock directly (time.Tick, time.After, time.Now)
+// can only be tested by sleeping, which makes tests slow when the durations are generous
+// and flaky when they are not. By reading time through a Clock, production code keeps
+// using the real clock, while tests drive a virtual one and decide exactly when time passes.
+
+// Clock is the source of time for code that needs to be tested without sleeping
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// Since returns the time elapsed since t
+	Since(t time.Time) time.Duration
+	// After waits for the duration to elapse and then sends the current time on the channel
+	After(d time.Duration) <-chan time.Time
+	// NewTicker returns a ticker that ticks on the given period
+	NewTicker(d time.Duration) Ticker
+	// NewTimer returns a timer that fires once after the given duration
+	NewTimer(d time.Duration) Timer
+}
+
+// Ticker is the interface over time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer is the interface over time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// New returns a clock backed by the wall clock
+func New() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+func (realClock) NewTimer(d time.Duration) Timer         { return realTimer{time.NewTimer(d)} }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+// Virtual is a clock whose time only moves when it is advanced. Tickers and timers created
+// from it fire synchronously within Advance, and just like the real ones, they drop ticks
+// that no one was around to receive. It is safe for concurrent use.
+type Virtual struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+	// changed is closed and replaced every time a waiter is added, so that BlockUntil
+	// can wait for the code under test to create its tickers and timers
+	changed chan struct{}
+}
+
+// NewVirtual returns a virtual clock whose current time is start
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{now: start, changed: make(chan struct{})}
+}
+
+// Now returns the current virtual time
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+// Since returns the virtual time elapsed since t
+func (v *Virtual) Since(t time.Time) time.Duration {
+	return v.Now().Sub(t)
+}
+
+// After waits for the virtual duration to elapse and then sends the time on the channel
+func (v *Virtual) After(d time.Duration) <-chan time.Time {
+	return v.NewTimer(d).C()
+}
+
+// NewTicker returns a ticker that ticks every period of virtual time
+func (v *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return virtualTicker{v.add(d, d)}
+}
+
+// NewTimer returns a timer that fires once the virtual duration has elapsed
+func (v *Virtual) NewTimer(d time.Duration) Timer {
+	return v.add(d, 0)
+}
+
+// Advance moves the virtual time forward by d, firing every ticker and timer that falls due
+// in the order they fall due
+func (v *Virtual) Advance(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	target := v.now.Add(d)
+	for {
+		w := v.earliest()
+		if w == nil || w.at.After(target) {
+			break
+		}
+		v.now = w.at
+		w.fire(v.now)
+	}
+	v.now = target
+}
+
+// BlockUntil blocks until at least n tickers and timers are waiting on the clock
+func (v *Virtual) BlockUntil(n int) {
+	for {
+		v.mu.Lock()
+		waiting, changed := len(v.waiters), v.changed
+		v.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+func (v *Virtual) add(d, period time.Duration) *waiter {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	w := &waiter{clock: v, c: make(chan time.Time, 1), period: period}
+	w.schedule(v.now.Add(d))
+	return w
+}
+
+// earliest returns the waiter which falls due first, must be called with the lock held
+func (v *Virtual) earliest() *waiter {
+	if len(v.waiters) == 0 {
+		return nil
+	}
+	sort.SliceStable(v.waiters, func(i, j int) bool { return v.waiters[i].at.Before(v.waiters[j].at) })
+	return v.waiters[0]
+}
+
+// virtualTicker hides the return value of Stop, which only timers report
+type virtualTicker struct{ w *waiter }
+
+func (t virtualTicker) C() <-chan time.Time { return t.w.c }
+func (t virtualTicker) Stop()               { t.w.Stop() }
+
+// waiter is a ticker when period is positive and a timer otherwise
+type waiter struct {
+	clock  *Virtual
+	c      chan time.Time
+	at     time.Time
+	period time.Duration
+}
+
+func (w *waiter) C() <-chan time.Time {
+	return w.c
+}
+
+// fire must be called with the lock held
+func (w *waiter) fire(now time.Time) {
+	select {
+	case w.c <- now:
+	default:
+	}
+	if w.period > 0 {
+		w.at = w.at.Add(w.period)
+		return
+	}
+	w.unschedule()
+}
+
+// schedule must be called with the lock held
+func (w *waiter) schedule(at time.Time) {
+	w.at = at
+	w.clock.waiters = append(w.clock.waiters, w)
+	close(w.clock.changed)
+	w.clock.changed = make(chan struct{})
+}
+
+// unschedule must be called with the lock held, it reports whether the waiter was scheduled
+func (w *waiter) unschedule() bool {
+	for i, other := range w.clock.waiters {
+		if other == w {
+			w.clock.waiters = append(w.clock.waiters[:i], w.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *waiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	return w.unschedule()
+}
+
+func (w *waiter) Reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+	active := w.unschedule()
+	w.schedule(w.clock.now.Add(d))
+	return active
+}
@@ -0,0 +1,71 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+func TestVirtualTicker(t *testing.T) {
+	start := time.Unix(0, 0)
+	clk := NewVirtual(start)
+	ticker := clk.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	clk.Advance(999 * time.Millisecond)
+	assert.Len(t, ticker.C(), 0)
+
+	clk.Advance(time.Millisecond)
+	assert.Equal(t, start.Add(time.Second), <-ticker.C())
+
+	// just like a real ticker, ticks no one received are dropped
+	clk.Advance(3 * time.Second)
+	assert.Equal(t, start.Add(2*time.Second), <-ticker.C())
+	assert.Len(t, ticker.C(), 0)
+	assert.Equal(t, 4*time.Second, clk.Since(start))
+}
+
+func TestVirtualTimer(t *testing.T) {
+	clk := NewVirtual(time.Unix(0, 0))
+	timer := clk.NewTimer(time.Second)
+
+	assert.True(t, timer.Reset(2*time.Second))
+	clk.Advance(time.Second)
+	assert.Len(t, timer.C(), 0)
+	clk.Advance(time.Second)
+	<-timer.C()
+	assert.False(t, timer.Stop())
+
+	after := clk.After(time.Minute)
+	clk.Advance(time.Hour)
+	assert.Equal(t, time.Unix(62, 0), <-after)
+}
+
+func TestVirtualBlockUntil(t *testing.T) {
+	clk := NewVirtual(time.Unix(0, 0))
+	fired := make(chan time.Time)
+	go func() {
+		fired <- <-clk.After(time.Second)
+	}()
+
+	// without blocking, we could advance before the goroutine starts waiting
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	assert.Equal(t, time.Unix(1, 0), <-fired)
+}
+
+func TestRealClock(t *testing.T) {
+	clk := New()
+	ticker := clk.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	<-ticker.C()
+	<-clk.NewTimer(time.Millisecond).C()
+	<-clk.After(time.Millisecond)
+	assert.True(t, clk.Since(clk.Now()) < time.Second)
+}
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
@@ -0,0 +1,178 @@
+package contexts
+
+import (
+	"context"
+	"fmt"
+	"patterns/clock"
+	"sync"
+	"time"
+)
+
+// Zen: A deadline says how long a request may take, but not whether the work left can fit in
+// it. locale() checks its own cost against the deadline and fails early rather than starting
+// work that is doomed to be cut short. A budget does the same for any operation: it estimates
+// what the operation costs from how long it took before, and refuses it, or falls back on a
+// cheaper one, when the time left can't cover it. A request made of several sub-calls can also
+// split its deadline among them, so that the first one can't eat up the time of the others.
+
+// Estimator learns what operations cost from their past latencies, weighting recent ones more
+// through an exponentially weighted moving average. It is safe for concurrent use.
+type Estimator struct {
+	alpha float64
+
+	mu    sync.Mutex
+	costs map[string]time.Duration
+}
+
+// NewEstimator returns an estimator that weighs each new latency by alpha, between 0 and 1.
+// The higher alpha, the faster estimates follow changes; an alpha of 0 keeps them as seeded.
+func NewEstimator(alpha float64, seeds map[string]time.Duration) *Estimator {
+	costs := make(map[string]time.Duration, len(seeds))
+	for op, cost := range seeds {
+		costs[op] = cost
+	}
+	return &Estimator{alpha: alpha, costs: costs}
+}
+
+// Observe records a latency of op. The first one of an unseeded op becomes its estimate.
+func (e *Estimator) Observe(op string, latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	cost, ok := e.costs[op]
+	if !ok {
+		e.costs[op] = latency
+		return
+	}
+	e.costs[op] = time.Duration(e.alpha*float64(latency) + (1-e.alpha)*float64(cost))
+}
+
+// Estimate returns the estimated cost of op, if anything is known about it
+func (e *Estimator) Estimate(op string) (time.Duration, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	cost, ok := e.costs[op]
+	return cost, ok
+}
+
+// BudgetError is returned for an operation refused for lack of time. It matches
+// context.DeadlineExceeded, as the deadline would have passed before the operation was done.
+type BudgetError struct {
+	Op        string
+	Estimate  time.Duration
+	Remaining time.Duration
+}
+
+func (err *BudgetError) Error() string {
+	return fmt.Sprintf("not enough time left for %v: estimated %v, %v left", err.Op, err.Estimate, err.Remaining)
+}
+
+// Is matches context.DeadlineExceeded
+func (err *BudgetError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// Budget weighs operations against the deadline of their context. It is safe for concurrent use.
+type Budget struct {
+	estimator *Estimator
+	clk       clock.Clock
+}
+
+// NewBudget returns a budget that estimates costs with the given estimator, and reads the time
+// left until deadlines from the given clock, the wall clock if nil. Context deadlines are wall
+// clock times, and the contexts Split returns expire on the wall clock too, so the clock must
+// track it: a virtual clock only suits tests, started at the wall time and advanced by less than
+// the deadlines at stake.
+func NewBudget(estimator *Estimator, clk clock.Clock) *Budget {
+	if clk == nil {
+		clk = clock.New()
+	}
+	return &Budget{estimator: estimator, clk: clk}
+}
+
+// Remaining returns the time left until the deadline of ctx, if it has one
+func (b *Budget) Remaining(ctx context.Context) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return deadline.Sub(b.clk.Now()), true
+}
+
+// CanAfford reports whether op is expected to finish before the deadline of ctx. Operations are
+// always affordable without a deadline, and for lack of an estimate, as long as time is left.
+func (b *Budget) CanAfford(ctx context.Context, op string) bool {
+	return b.check(ctx, op) == nil
+}
+
+func (b *Budget) check(ctx context.Context, op string) error {
+	remaining, ok := b.Remaining(ctx)
+	if !ok {
+		return nil
+	}
+	cost, _ := b.estimator.Estimate(op)
+	if remaining <= 0 || cost >= remaining {
+		return &BudgetError{Op: op, Estimate: cost, Remaining: remaining}
+	}
+	return nil
+}
+
+// Run calls fn if op can be afforded, and returns a *BudgetError otherwise
+func (b *Budget) Run(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	_, err := Do(ctx, b, op, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	})
+	return err
+}
+
+// Do is Run for operations that return a value. The latency of every successful call is fed
+// back to the estimator, those that fail being likely cut short.
+func Do[T any](ctx context.Context, b *Budget, op string, fn func(ctx context.Context) (T, error)) (T, error) {
+	if err := b.check(ctx, op); err != nil {
+		var zero T
+		return zero, err
+	}
+	start := b.clk.Now()
+	v, err := fn(ctx)
+	if err == nil {
+		b.estimator.Observe(op, b.clk.Since(start))
+	}
+	return v, err
+}
+
+// DoOrDegrade is Do, except that when op can't be afforded it calls degraded instead, usually a
+// cheaper way to a less complete result, such as a cached value or a default
+func DoOrDegrade[T any](ctx context.Context, b *Budget, op string, fn, degraded func(ctx context.Context) (T, error)) (T, error) {
+	if !b.CanAfford(ctx, op) {
+		return degraded(ctx)
+	}
+	return Do(ctx, b, op, fn)
+}
+
+// Split hands out the time left until the deadline of ctx to sequential sub-calls, by weight.
+// Every call to the returned function returns the context of the next sub-call, whose share is
+// taken from the time left when it starts, so that the time saved by a fast sub-call goes to
+// those after it. Calls past the last weight get whatever time is left. Without a deadline,
+// sub-calls get none either. Shares are measured on the clock of the budget, but the deadlines
+// they make are enforced by the context package, on the wall clock.
+func (b *Budget) Split(ctx context.Context, weights ...float64) func() (context.Context, context.CancelFunc) {
+	next := 0
+	var mu sync.Mutex
+	return func() (context.Context, context.CancelFunc) {
+		mu.Lock()
+		defer mu.Unlock()
+		remaining, ok := b.Remaining(ctx)
+		if !ok || next >= len(weights) {
+			return context.WithCancel(ctx)
+		}
+		left := 0.0
+		for _, w := range weights[next:] {
+			left += w
+		}
+		share := remaining
+		if left > 0 {
+			share = time.Duration(float64(remaining) * weights[next] / left)
+		}
+		next++
+		return context.WithDeadline(ctx, b.clk.Now().Add(share))
+	}
+}
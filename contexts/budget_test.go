@@ -0,0 +1,122 @@
+package contexts
+
+import (
+	"context"
+	"errors"
+	"patterns/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withDeadlineIn returns a context whose deadline is d away on the virtual clock. The context
+// still expires on the wall clock, which is why the virtual clocks here start at the wall time.
+func withDeadlineIn(clk *clock.Virtual, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithDeadline(context.Background(), clk.Now().Add(d))
+}
+
+func TestEstimator(t *testing.T) {
+	e := NewEstimator(0.5, map[string]time.Duration{"seeded": 100 * time.Millisecond})
+	_, ok := e.Estimate("unknown")
+	assert.False(t, ok)
+
+	e.Observe("seeded", 200*time.Millisecond)
+	cost, _ := e.Estimate("seeded")
+	assert.Equal(t, 150*time.Millisecond, cost)
+
+	e.Observe("learned", 40*time.Millisecond)
+	e.Observe("learned", 20*time.Millisecond)
+	cost, _ = e.Estimate("learned")
+	assert.Equal(t, 30*time.Millisecond, cost)
+}
+
+func TestBudgetRefusesWhatCantFinishInTime(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	b := NewBudget(NewEstimator(0.5, map[string]time.Duration{"slow": time.Minute, "fast": time.Millisecond}), clk)
+	ctx, cancel := withDeadlineIn(clk, time.Second)
+	defer cancel()
+
+	assert.True(t, b.CanAfford(ctx, "fast"))
+	assert.True(t, b.CanAfford(ctx, "unknown"))
+	called := false
+	err := b.Run(ctx, "slow", func(context.Context) error {
+		called = true
+		return nil
+	})
+	assert.False(t, called)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	var budgetErr *BudgetError
+	assert.True(t, errors.As(err, &budgetErr))
+	assert.Equal(t, &BudgetError{Op: "slow", Estimate: time.Minute, Remaining: time.Second}, budgetErr)
+
+	// without a deadline, anything goes
+	assert.True(t, b.CanAfford(context.Background(), "slow"))
+}
+
+func TestBudgetLearnsFromLatencies(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	b := NewBudget(NewEstimator(1, nil), clk)
+	ctx, cancel := withDeadlineIn(clk, 500*time.Millisecond)
+	defer cancel()
+
+	v, err := Do(ctx, b, "query", func(context.Context) (string, error) {
+		clk.Advance(400 * time.Millisecond)
+		return "rows", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "rows", v)
+	// 100ms are left, and the query is now known to take 400ms
+	assert.False(t, b.CanAfford(ctx, "query"))
+
+	degraded, err := DoOrDegrade(ctx, b, "query", func(context.Context) (string, error) {
+		return "rows", nil
+	}, func(context.Context) (string, error) {
+		return "cached rows", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "cached rows", degraded)
+}
+
+func TestBudgetSplit(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	b := NewBudget(NewEstimator(0, nil), clk)
+	ctx, cancel := withDeadlineIn(clk, 600*time.Millisecond)
+	defer cancel()
+
+	next := b.Split(ctx, 1, 1, 1)
+	first, cancelFirst := next()
+	defer cancelFirst()
+	remaining, _ := b.Remaining(first)
+	assert.Equal(t, 200*time.Millisecond, remaining)
+
+	// the first sub-call only took 50ms, the rest is shared by the other two
+	clk.Advance(50 * time.Millisecond)
+	second, cancelSecond := next()
+	defer cancelSecond()
+	remaining, _ = b.Remaining(second)
+	assert.Equal(t, 275*time.Millisecond, remaining)
+
+	clk.Advance(275 * time.Millisecond)
+	third, cancelThird := next()
+	defer cancelThird()
+	remaining, _ = b.Remaining(third)
+	assert.Equal(t, 275*time.Millisecond, remaining)
+
+	sub, cancelSub := b.Split(context.Background(), 1, 1)()
+	defer cancelSub()
+	_, ok := sub.Deadline()
+	assert.False(t, ok)
+}
+
+func TestLocaleRefusesUpFront(t *testing.T) {
+	ctx := configKey.WithValue(context.Background(), contextConfiguration{localeComputeTime: time.Minute})
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+
+	start := time.Now()
+	_, err := locale(ctx, "test")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	// refused on its configured cost, without waiting for the deadline
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+}
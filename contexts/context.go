@@ -2,8 +2,8 @@ package contexts
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"sync"
 	"time"
 )
 
@@ -35,58 +35,45 @@ type contextConfiguration struct {
 	localeComputeTime time.Duration
 }
 
-// good practice defining a key type for your context baggage and then explicit methods
-// to get keys out of that baggage
-type ctxKey string
+// defaultConfiguration stands in for the configuration when the context has none, say because
+// the middleware setting it was left out: greet waits a second for locale, which is instant
+var defaultConfiguration = contextConfiguration{greetDelay: time.Second}
 
-const ctxConfigKey ctxKey = "config"
+// good practice defining a typed key for your context baggage, which owns the type of its
+// values and the fallback when they are missing, instead of asserting on ctx.Value
+var configKey = NewKeyWithDefault("config", defaultConfiguration).Listed()
 
 // type safe way to get greet delay duration from the context baggage
 func greetDelay(ctx context.Context) time.Duration {
-	return ctx.Value(ctxConfigKey).(contextConfiguration).greetDelay
+	return configKey.Value(ctx).greetDelay
 }
 
 // type safe way to get locale cost duration from the context baggage
 func localeComputeTime(ctx context.Context) time.Duration {
-	return ctx.Value(ctxConfigKey).(contextConfiguration).localeComputeTime
+	return configKey.Value(ctx).localeComputeTime
 }
 
 func printGreetAndFarewellWith1SecGreetDeadlineCancelsFarewell(config contextConfiguration) (*result, *result) {
-	ctx, cancel := context.WithCancel(context.Background())
 	// the key you use in the context bag must satisfy comparability and must be safe to
 	// access from multiple routines
-	ctx = context.WithValue(ctx, ctxConfigKey, config)
-	var wg sync.WaitGroup
-	farewellResult := &result{}
-	greetResult := &result{}
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if msg, err := genGreeting(ctx); err != nil {
-			fmt.Println("cannot print greeting. Cancelling context. Err:", err)
-			greetResult.msg = "cannot print greeting"
-			greetResult.err = err
-			// call cancel() so that any part of the graph depending on this ctx is cancelled
-			cancel()
-		} else {
-			greetResult.msg = msg
-		}
-	}()
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if msg, err := genFarewell(ctx); err != nil {
-			fmt.Println("cannot print farewell. Cancelling context. Err:", err)
-			farewellResult.msg = "cannot print farewell"
-			farewellResult.err = err
-			// no need to call cancel here
-		} else {
-			farewellResult.msg = msg
-		}
-	}()
-	wg.Wait()
-	return greetResult, farewellResult
+	ctx := configKey.WithValue(context.Background(), config)
+	group, ctx := NewGroup[string](ctx)
+	// a failed greeting cancels the group's context, so that any part of the graph depending
+	// on it, such as the farewell, is cancelled
+	group.Go("greeting", TaskOptions{CancelOnFailure: true}, genGreeting)
+	// no need to cancel anything when the farewell fails
+	group.Go("farewell", TaskOptions{}, genFarewell)
+	results, _ := group.Wait()
+
+	return toResult(results[0]), toResult(results[1])
+}
+
+func toResult(r TaskResult[string]) *result {
+	if r.Err != nil {
+		fmt.Printf("cannot print %v. Err: %v\n", r.Task, r.Err)
+		return &result{msg: "cannot print " + r.Task, err: r.Err}
+	}
+	return &result{msg: r.Value}
 }
 
 func genGreeting(ctx context.Context) (string, error) {
@@ -120,20 +107,23 @@ func genFarewell(ctx context.Context) (string, error) {
 func locale(ctx context.Context, caller string) (string, error) {
 	// as an optimization we can early check whether we are going to meet the deadline
 	// received in the context vs what the function is set to take (if it is set)
+	// here the cost is known from the configuration of the request, so it is seeded per call
+	// and never learned, or one request's cost would refuse another's
 	localeCostTime := localeComputeTime(ctx)
-	if deadline, ok := ctx.Deadline(); ok {
-		// there is a deadline set here
-		if deadline.Sub(time.Now().Add(localeCostTime)) <= 0 {
-			fmt.Println("There's no point in continuing locale as we'd exceed the deadline set by", caller)
-			return "", context.DeadlineExceeded
+	budget := NewBudget(NewEstimator(0, map[string]time.Duration{"locale": localeCostTime}), nil)
+	v, err := Do(ctx, budget, "locale", func(ctx context.Context) (string, error) {
+		select {
+		case <-ctx.Done():
+			fmt.Println("context is closed inside locale() called by", caller)
+			return "", ctx.Err()
+		case <-time.After(localeCostTime):
 		}
+		return "EN/US", nil
+	})
+	var budgetErr *BudgetError
+	if errors.As(err, &budgetErr) {
+		fmt.Println("There's no point in continuing locale as we'd exceed the deadline set by", caller)
+		return "", context.DeadlineExceeded
 	}
-
-	select {
-	case <-ctx.Done():
-		fmt.Println("context is closed inside locale() called by", caller)
-		return "", ctx.Err()
-	case <-time.After(localeCostTime):
-	}
-	return "EN/US", nil
+	return v, err
 }
@@ -0,0 +1,78 @@
+package contexts
+
+import (
+	"context"
+	eh "patterns/error_handling"
+	"sync"
+)
+
+// Zen: Sibling goroutines working on the same request often share its fate: when one fails, the
+// work of the others is wasted, and they should be cancelled. Not always though: a failed
+// farewell says nothing about the greeting. A group runs named tasks under one context, and each
+// task says whether its failure cancels the others. The context is then cancelled with a cause
+// naming the task and its error: the others only see context.Canceled from ctx.Err(), but anyone
+// can ask context.Cause(ctx) why. It builds on error_handling.ErrorGroup, adding the result of
+// every task to the errors gathered.
+
+// TaskOptions configure a task of a group
+type TaskOptions struct {
+	// CancelOnFailure cancels the other tasks once this one fails
+	CancelOnFailure bool
+}
+
+// TaskResult is what a task of a group returned
+type TaskResult[T any] struct {
+	Task  string
+	Value T
+	Err   error
+}
+
+// Group runs named tasks under a shared context. It must not be reused once waited on.
+type Group[T any] struct {
+	errs *eh.ErrorGroup
+
+	mu      sync.Mutex
+	results []TaskResult[T]
+}
+
+// NewGroup returns a group and the context its tasks run under, derived from ctx
+func NewGroup[T any](ctx context.Context) (*Group[T], context.Context) {
+	errs, ctx := eh.NewErrorGroup(ctx, eh.CollectAll)
+	return &Group[T]{errs: errs}, ctx
+}
+
+// Go runs fn as the task called name, in its own goroutine. Should it fail and be set to cancel
+// the others, the context of the group is cancelled with an error_handling.ItemError as cause.
+func (g *Group[T]) Go(name string, opts TaskOptions, fn func(ctx context.Context) (T, error)) {
+	g.mu.Lock()
+	i := len(g.results)
+	g.results = append(g.results, TaskResult[T]{Task: name})
+	g.mu.Unlock()
+
+	g.errs.Go(name, func(ctx context.Context) error {
+		v, err := fn(ctx)
+		g.mu.Lock()
+		g.results[i].Value, g.results[i].Err = v, err
+		g.mu.Unlock()
+		if err != nil && opts.CancelOnFailure {
+			g.errs.Cancel(eh.ItemError{Key: name, Err: err})
+		}
+		return err
+	})
+}
+
+// Wait waits for every task, and returns their results in the order they were started, along
+// with an *error_handling.MultiError of their failures, if any. The tasks which failed only
+// because another one cancelled them are left out of it, though not out of their results. The
+// context of the group is cancelled once Wait returns.
+func (g *Group[T]) Wait() ([]TaskResult[T], error) {
+	err := g.errs.Wait()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.results, err
+}
+
+// Cause returns why the context of the group was cancelled, see ErrorGroup.Cause
+func (g *Group[T]) Cause() error {
+	return g.errs.Cause()
+}
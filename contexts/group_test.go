@@ -0,0 +1,68 @@
+package contexts
+
+import (
+	"context"
+	"errors"
+	eh "patterns/error_handling"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupCancelsSiblingsWithCause(t *testing.T) {
+	errBoom := errors.New("boom")
+	group, ctx := NewGroup[int](context.Background())
+	group.Go("waits", TaskOptions{}, func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	group.Go("fails", TaskOptions{CancelOnFailure: true}, func(context.Context) (int, error) {
+		return 0, errBoom
+	})
+
+	results, err := group.Wait()
+	// waits only failed because fails cancelled it, so only fails is reported
+	assert.EqualError(t, err, "fails: boom")
+	assert.ErrorIs(t, err, errBoom)
+	assert.Equal(t, []TaskResult[int]{{Task: "waits", Err: context.Canceled}, {Task: "fails", Err: errBoom}}, results)
+
+	var cause eh.ItemError
+	assert.True(t, errors.As(context.Cause(ctx), &cause))
+	assert.Equal(t, "fails", cause.Key)
+	assert.Equal(t, eh.ItemError{Key: "fails", Err: errBoom}, group.Cause())
+}
+
+func TestGroupFailureLeavesSiblingsAlone(t *testing.T) {
+	group, ctx := NewGroup[string](context.Background())
+	release := make(chan struct{})
+	group.Go("first", TaskOptions{}, func(context.Context) (string, error) {
+		defer close(release)
+		return "", errors.New("first failed")
+	})
+	group.Go("second", TaskOptions{CancelOnFailure: true}, func(ctx context.Context) (string, error) {
+		<-release
+		// the failure of first left the context alone
+		return "done", ctx.Err()
+	})
+
+	results, err := group.Wait()
+	assert.EqualError(t, err, "first: first failed")
+	assert.Equal(t, TaskResult[string]{Task: "second", Value: "done"}, results[1])
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	assert.Equal(t, context.Canceled, group.Cause())
+}
+
+func TestGroupParentCancelled(t *testing.T) {
+	parent, cancel := context.WithCancelCause(context.Background())
+	errShutdown := errors.New("shutting down")
+	group, _ := NewGroup[int](parent)
+	group.Go("waits", TaskOptions{CancelOnFailure: true}, func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	cancel(errShutdown)
+
+	results, _ := group.Wait()
+	assert.ErrorIs(t, results[0].Err, context.Canceled)
+	assert.Equal(t, errShutdown, group.Cause())
+}
@@ -0,0 +1,126 @@
+package contexts
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Zen: ctx.Value returns an interface{}, and asserting its type panics whenever the value is
+// missing, say because a middleware that sets it was left out. A typed key owns both the type
+// and the lookup: its values go in and come out as T, a missing value is reported rather than
+// panicked over, and a default can stand in for it. Every key being a distinct pointer, keys
+// from different packages never collide, whatever their names.
+
+// Key is a typed context key. Create keys with NewKey, usually as package level variables.
+type Key[T any] struct {
+	name       string
+	def        T
+	hasDefault bool
+}
+
+// NewKey returns a key for values of type T. The name is only used for debugging.
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+// NewKeyWithDefault returns a key whose Value falls back on def when the context has no value
+func NewKeyWithDefault[T any](name string, def T) *Key[T] {
+	return &Key[T]{name: name, def: def, hasDefault: true}
+}
+
+// Listed adds the key to those TypedValues looks up, and returns it. A listed key is kept for as
+// long as the process runs, so only keys held by package level variables should be, as in
+// var userKey = contexts.NewKey[string]("user").Listed(). Listing a key twice lists it once.
+func (k *Key[T]) Listed() *Key[T] {
+	register(k)
+	return k
+}
+
+// WithValue returns a copy of ctx holding v under the key
+func (k *Key[T]) WithValue(ctx context.Context, v T) context.Context {
+	return context.WithValue(ctx, k, v)
+}
+
+// Get returns the value ctx holds under the key, and whether it holds one. If it doesn't, the
+// default of the key is returned, if it has one.
+func (k *Key[T]) Get(ctx context.Context) (T, bool) {
+	v, ok := ctx.Value(k).(T)
+	if !ok {
+		return k.def, false
+	}
+	return v, true
+}
+
+// Value returns the value ctx holds under the key, or the default of the key
+func (k *Key[T]) Value(ctx context.Context) T {
+	v, _ := k.Get(ctx)
+	return v
+}
+
+// MustGet returns the value ctx holds under the key, or the default of the key. It panics if
+// there is neither, which should be kept for values without which nothing can be done.
+func (k *Key[T]) MustGet(ctx context.Context) T {
+	v, ok := k.Get(ctx)
+	if !ok && !k.hasDefault {
+		panic(fmt.Sprintf("contexts: no value for key %v in context", k))
+	}
+	return v
+}
+
+func (k *Key[T]) String() string {
+	var zero T
+	return fmt.Sprintf("%v(%T)", k.name, zero)
+}
+
+// TypedValue is a value held by a context under a typed key
+type TypedValue struct {
+	Key   string
+	Value interface{}
+}
+
+// TypedValues lists the values ctx holds under listed keys, in the order the keys were listed.
+// It is meant for debugging, such as logging what a request carries.
+func TypedValues(ctx context.Context) []TypedValue {
+	registry.mu.Lock()
+	keys := append([]registeredKey(nil), registry.keys...)
+	registry.mu.Unlock()
+
+	var values []TypedValue
+	for _, k := range keys {
+		if v, ok := k.lookup(ctx); ok {
+			values = append(values, TypedValue{Key: k.String(), Value: v})
+		}
+	}
+	return values
+}
+
+type registeredKey interface {
+	fmt.Stringer
+	lookup(ctx context.Context) (interface{}, bool)
+}
+
+func (k *Key[T]) lookup(ctx context.Context) (interface{}, bool) {
+	v, ok := ctx.Value(k).(T)
+	return v, ok
+}
+
+// registry holds every listed key, so that TypedValues can look them all up
+var registry struct {
+	mu     sync.Mutex
+	keys   []registeredKey
+	listed map[registeredKey]bool
+}
+
+func register(k registeredKey) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if registry.listed[k] {
+		return
+	}
+	if registry.listed == nil {
+		registry.listed = make(map[registeredKey]bool)
+	}
+	registry.listed[k] = true
+	registry.keys = append(registry.keys, k)
+}
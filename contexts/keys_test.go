@@ -0,0 +1,58 @@
+package contexts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKey(t *testing.T) {
+	user := NewKey[string]("user")
+	attempts := NewKeyWithDefault("attempts", 3)
+	ctx := context.Background()
+
+	_, ok := user.Get(ctx)
+	assert.False(t, ok)
+	assert.PanicsWithValue(t, "contexts: no value for key user(string) in context", func() { user.MustGet(ctx) })
+	v, ok := attempts.Get(ctx)
+	assert.False(t, ok)
+	assert.Equal(t, 3, v)
+	assert.Equal(t, 3, attempts.MustGet(ctx))
+
+	ctx = attempts.WithValue(user.WithValue(ctx, "gopher"), 5)
+	assert.Equal(t, "gopher", user.MustGet(ctx))
+	assert.Equal(t, 5, attempts.Value(ctx))
+
+	// keys with the same name and type are still distinct
+	other := NewKey[string]("user")
+	_, ok = other.Get(ctx)
+	assert.False(t, ok)
+}
+
+// listed keys are package level, as they are kept for good
+var (
+	tenantKey = NewKey[string]("tenant").Listed()
+	limitKey  = NewKey[int]("limit").Listed().Listed()
+	_         = NewKey[bool]("unset").Listed()
+)
+
+func TestTypedValues(t *testing.T) {
+	unlisted := NewKey[string]("request")
+
+	ctx := limitKey.WithValue(tenantKey.WithValue(context.Background(), "acme"), 10)
+	ctx = unlisted.WithValue(ctx, "req-1")
+	assert.Equal(t, []TypedValue{{Key: "tenant(string)", Value: "acme"}, {Key: "limit(int)", Value: 10}}, TypedValues(ctx))
+	assert.Empty(t, TypedValues(context.Background()))
+}
+
+func TestConfigurationMissingFromContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, time.Second, greetDelay(ctx))
+	assert.Equal(t, time.Duration(0), localeComputeTime(ctx))
+
+	msg, err := genGreeting(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", msg)
+}
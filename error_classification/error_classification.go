@@ -0,0 +1,171 @@
+package error_classification
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Zen: Whether an error is worth retrying is a decision that the code raising it is best placed
+// to make, while the code retrying it is far removed from it. Tagging an error with its class
+// lets the two meet without knowing about each other: retries, circuit breakers and the like
+// only ever ask for the class. Errors that come from the standard library, such as context
+// errors, network timeouts and failed connections, are classified without having to be tagged.
+
+// Class tells what kind of failure an error is, and hence whether it is worth retrying
+type Class int
+
+const (
+	// Unknown is an error that was neither tagged nor recognised
+	Unknown Class = iota
+	// Transient is a failure that may go away by itself, such as a dropped connection
+	Transient
+	// Permanent is a failure that will happen again for the same request, such as a bad input
+	Permanent
+	// RateLimited is a failure caused by sending too many requests, which may carry a hint
+	// of when to try again
+	RateLimited
+	// Timeout is a failure to get a reply in time
+	Timeout
+)
+
+func (c Class) String() string {
+	switch c {
+	case Transient:
+		return "transient"
+	case Permanent:
+		return "permanent"
+	case RateLimited:
+		return "rate-limited"
+	case Timeout:
+		return "timeout"
+	}
+	return "unknown"
+}
+
+// Retryable reports whether errors of the class are worth retrying
+func (c Class) Retryable() bool {
+	return c == Transient || c == RateLimited || c == Timeout
+}
+
+// Classifier is implemented by errors that know their own class
+type Classifier interface {
+	Class() Class
+}
+
+// classified is an error tagged with a class
+type classified struct {
+	error
+	class      Class
+	retryAfter time.Duration
+}
+
+func (err classified) Class() Class {
+	return err.class
+}
+
+func (err classified) Unwrap() error {
+	return err.error
+}
+
+// RetryAfter returns the hint of when to try again, zero if there is none
+func (err classified) RetryAfter() time.Duration {
+	return err.retryAfter
+}
+
+// Tag tags err with the given class, which takes precedence over any class found below it
+func Tag(err error, class Class) error {
+	if err == nil {
+		return nil
+	}
+	return classified{error: err, class: class}
+}
+
+// WithRetryAfter tags err as rate limited, with a hint of how long to wait before trying again
+func WithRetryAfter(err error, after time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return classified{error: err, class: RateLimited, retryAfter: after}
+}
+
+// RetryAfter returns the hint of how long to wait before trying again carried by err, if any
+func RetryAfter(err error) (time.Duration, bool) {
+	var hinted interface{ RetryAfter() time.Duration }
+	if errors.As(err, &hinted) && hinted.RetryAfter() > 0 {
+		return hinted.RetryAfter(), true
+	}
+	return 0, false
+}
+
+// Classify returns the class of err. The outermost tag wins, otherwise context errors, network
+// timeouts and failed connections are recognised, and anything else is Unknown.
+func Classify(err error) Class {
+	if err == nil {
+		return Unknown
+	}
+	var classifier Classifier
+	if errors.As(err, &classifier) {
+		return classifier.Class()
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return Timeout
+	case errors.Is(err, context.Canceled):
+		// the caller gave up, there's no one left to retry for
+		return Permanent
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return Timeout
+	}
+	if isConnectionFailure(err) {
+		return Transient
+	}
+	return Unknown
+}
+
+// isConnectionFailure reports whether err is a connection that couldn't be made or was lost,
+// which the next one may well not be. A host that doesn't exist isn't one of them.
+func isConnectionFailure(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	// an http client reports a connection closed before the reply as a bare io.EOF
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && errors.Is(urlErr.Err, io.EOF) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// IsRetryable reports whether err is worth retrying
+func IsRetryable(err error) bool {
+	return Classify(err).Retryable()
+}
+
+// FromStatus returns the class of an HTTP response status, Unknown for those that aren't errors
+func FromStatus(code int) Class {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return RateLimited
+	case code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout:
+		return Timeout
+	case code >= 500:
+		return Transient
+	case code >= 400:
+		return Permanent
+	}
+	return Unknown
+}
@@ -0,0 +1,79 @@
+package error_classification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	root := errors.New("boom")
+	cases := []struct {
+		name  string
+		err   error
+		class Class
+	}{
+		{name: "nil", err: nil, class: Unknown},
+		{name: "untagged", err: root, class: Unknown},
+		{name: "tagged", err: Tag(root, Transient), class: Transient},
+		{name: "tagged and wrapped", err: fmt.Errorf("calling: %w", Tag(root, Permanent)), class: Permanent},
+		{name: "outermost tag wins", err: Tag(Tag(root, Transient), Permanent), class: Permanent},
+		{name: "tag wins over context", err: Tag(context.DeadlineExceeded, Permanent), class: Permanent},
+		{name: "deadline", err: fmt.Errorf("calling: %w", context.DeadlineExceeded), class: Timeout},
+		{name: "cancelled", err: context.Canceled, class: Permanent},
+		{name: "net timeout", err: &net.DNSError{Err: "i/o timeout", IsTimeout: true}, class: Timeout},
+		{name: "net not timeout", err: &net.DNSError{Err: "no such host", IsNotFound: true}, class: Unknown},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, class: Transient},
+		{name: "connection reset", err: fmt.Errorf("reading: %w", syscall.ECONNRESET), class: Transient},
+		{name: "unexpected eof", err: fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), class: Transient},
+		{name: "closed before reply", err: &url.Error{Op: "Get", URL: "http://example.com", Err: io.EOF}, class: Transient},
+		{name: "bare eof", err: io.EOF, class: Unknown},
+		{name: "net op", err: &net.OpError{Op: "read", Net: "tcp", Err: root}, class: Transient},
+		{name: "dial unknown host", err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}, class: Unknown},
+		{name: "rate limited", err: WithRetryAfter(root, time.Second), class: RateLimited},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.class, Classify(tc.err))
+			assert.Equal(t, tc.class.Retryable(), IsRetryable(tc.err))
+		})
+	}
+}
+
+func TestTagKeepsCause(t *testing.T) {
+	root := errors.New("boom")
+	err := Tag(root, Transient)
+	assert.ErrorIs(t, err, root)
+	assert.Equal(t, "boom", err.Error())
+	assert.Nil(t, Tag(nil, Transient))
+	assert.Nil(t, WithRetryAfter(nil, time.Second))
+}
+
+func TestRetryAfter(t *testing.T) {
+	after, ok := RetryAfter(fmt.Errorf("calling: %w", WithRetryAfter(errors.New("429"), 3*time.Second)))
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, after)
+
+	_, ok = RetryAfter(Tag(errors.New("429"), RateLimited))
+	assert.False(t, ok)
+}
+
+func TestFromStatus(t *testing.T) {
+	assert.Equal(t, Unknown, FromStatus(http.StatusOK))
+	assert.Equal(t, Unknown, FromStatus(http.StatusFound))
+	assert.Equal(t, Permanent, FromStatus(http.StatusNotFound))
+	assert.Equal(t, RateLimited, FromStatus(http.StatusTooManyRequests))
+	assert.Equal(t, Timeout, FromStatus(http.StatusGatewayTimeout))
+	assert.Equal(t, Transient, FromStatus(http.StatusServiceUnavailable))
+	assert.Equal(t, "rate-limited", RateLimited.String())
+}
@@ -0,0 +1,157 @@
+package error_handling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Zen: ErrorHandlingThatIsAbleToPropagateValues stops at the first error, which is the right
+// call when one failure makes the whole batch useless. Often though, a batch fanned out to many
+// goroutines is only useful if we know everything that failed in it, along with which item it
+// failed for. An error group gathers the errors of its goroutines, keyed by the item they were
+// working on, and either cancels the rest on the first failure or lets all of them finish. Its
+// context is cancelled with a cause, so that the goroutines cancelled can tell which item failed.
+
+// Mode decides what an ErrorGroup does when one of its goroutines fails
+type Mode int
+
+const (
+	// CollectAll lets every goroutine run to completion and gathers all of their errors
+	CollectAll Mode = iota
+	// FailFast cancels the remaining goroutines as soon as one of them fails
+	FailFast
+)
+
+// ItemError is the error of a single item of a batch, along with the key identifying the item
+type ItemError struct {
+	Key string
+	Err error
+}
+
+func (err ItemError) Error() string {
+	return fmt.Sprintf("%v: %v", err.Key, err.Err)
+}
+
+func (err ItemError) Unwrap() error {
+	return err.Err
+}
+
+// MultiError is the aggregate of the errors of a batch, in the order they occurred
+type MultiError struct {
+	Errors []ItemError
+}
+
+func (err *MultiError) Error() string {
+	if len(err.Errors) == 1 {
+		return err.Errors[0].Error()
+	}
+	messages := make([]string, len(err.Errors))
+	for i, e := range err.Errors {
+		messages[i] = e.Error()
+	}
+	return fmt.Sprintf("%d errors occurred: %v", len(err.Errors), strings.Join(messages, "; "))
+}
+
+// Unwrap returns every item error, so that errors.Is and errors.As look into each of them
+func (err *MultiError) Unwrap() []error {
+	errs := make([]error, len(err.Errors))
+	for i, e := range err.Errors {
+		errs[i] = e
+	}
+	return errs
+}
+
+// ErrorGroup runs goroutines for the items of a batch and gathers their errors
+type ErrorGroup struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	mode   Mode
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	errs      []ItemError
+	failed    bool
+	cancelled bool
+}
+
+// NewErrorGroup returns a group along with the context its goroutines run under, which is
+// cancelled on the first failure in FailFast mode, with that failure's ItemError as the cause,
+// and once Wait returns in any mode
+func NewErrorGroup(ctx context.Context, mode Mode) (*ErrorGroup, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	return &ErrorGroup{ctx: ctx, cancel: cancel, mode: mode}, ctx
+}
+
+// Go runs fn for the item identified by key in a new goroutine. In FailFast mode, fn isn't run
+// at all if the group has already been cancelled.
+func (g *ErrorGroup) Go(key string, fn func(ctx context.Context) error) {
+	if g.mode == FailFast && g.isCancelled() {
+		return
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := fn(g.ctx); err != nil {
+			g.record(key, err)
+		}
+	}()
+}
+
+// Wait waits for every goroutine to return, and returns a *MultiError of their errors if any
+func (g *ErrorGroup) Wait() error {
+	g.wg.Wait()
+	g.cancel(nil)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.errs) == 0 {
+		return nil
+	}
+	return &MultiError{Errors: g.errs}
+}
+
+func (g *ErrorGroup) record(key string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// once we have cancelled the others, their cancellation is not news to anyone
+	if g.cancelled && errors.Is(err, context.Canceled) {
+		return
+	}
+	g.errs = append(g.errs, ItemError{Key: key, Err: err})
+	if !g.failed {
+		g.failed = true
+		if g.mode == FailFast {
+			g.cancelWith(ItemError{Key: key, Err: err})
+		}
+	}
+}
+
+// Cancel cancels the context of the group with the given cause, as a failure does in FailFast
+// mode. Only the first cause is kept, and the errors of the goroutines cancelled aren't reported.
+func (g *ErrorGroup) Cancel(cause error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.cancelWith(cause)
+}
+
+// Cause returns why the context of the group was cancelled: the ItemError of the failure or the
+// cause given to Cancel, the cause of the parent context if that was cancelled, context.Canceled
+// once Wait returned, and nil before
+func (g *ErrorGroup) Cause() error {
+	return context.Cause(g.ctx)
+}
+
+func (g *ErrorGroup) cancelWith(cause error) {
+	if !g.cancelled {
+		g.cancelled = true
+		g.cancel(cause)
+	}
+}
+
+func (g *ErrorGroup) isCancelled() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.cancelled
+}
@@ -0,0 +1,100 @@
+package error_handling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorGroupCollectAll(t *testing.T) {
+	errTimeout := errors.New("timeout")
+	group, _ := NewErrorGroup(context.Background(), CollectAll)
+	for i := 0; i < 5; i++ {
+		id := i
+		group.Go(fmt.Sprintf("item-%d", id), func(ctx context.Context) error {
+			switch id {
+			case 1:
+				return errTimeout
+			case 3:
+				time.Sleep(10 * time.Millisecond)
+				return &fs.PathError{Op: "open", Path: "/items/3", Err: fs.ErrNotExist}
+			}
+			return nil
+		})
+	}
+
+	err := group.Wait()
+	var multi *MultiError
+	assert.True(t, errors.As(err, &multi))
+	assert.Equal(t, []ItemError{
+		{Key: "item-1", Err: errTimeout},
+		{Key: "item-3", Err: &fs.PathError{Op: "open", Path: "/items/3", Err: fs.ErrNotExist}},
+	}, multi.Errors)
+	assert.EqualError(t, err, "2 errors occurred: item-1: timeout; item-3: open /items/3: file does not exist")
+
+	// every item error is visible through the aggregate
+	assert.ErrorIs(t, err, errTimeout)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	var pathErr *fs.PathError
+	assert.True(t, errors.As(err, &pathErr))
+	assert.Equal(t, "/items/3", pathErr.Path)
+}
+
+func TestErrorGroupFailFast(t *testing.T) {
+	errBadItem := errors.New("bad item")
+	group, ctx := NewErrorGroup(context.Background(), FailFast)
+	group.Go("slow", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Minute):
+			return nil
+		}
+	})
+	group.Go("bad", func(ctx context.Context) error {
+		return errBadItem
+	})
+
+	<-ctx.Done() // the failure cancels the rest of the batch
+	ran := false
+	group.Go("late", func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	// the cancelled items can tell which item failed, and why
+	assert.Equal(t, ItemError{Key: "bad", Err: errBadItem}, context.Cause(ctx))
+	assert.Equal(t, context.Cause(ctx), group.Cause())
+
+	err := group.Wait()
+	assert.False(t, ran)
+	// the slow item was only cancelled because of the bad one, so it's not reported
+	assert.EqualError(t, err, "bad: bad item")
+	assert.ErrorIs(t, err, errBadItem)
+}
+
+func TestErrorGroupCancel(t *testing.T) {
+	errShutdown := errors.New("shutting down")
+	group, ctx := NewErrorGroup(context.Background(), CollectAll)
+	group.Go("waits", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	group.Cancel(errShutdown)
+	group.Cancel(errors.New("too late"))
+
+	assert.NoError(t, group.Wait())
+	assert.Equal(t, errShutdown, context.Cause(ctx))
+}
+
+func TestErrorGroupNoErrors(t *testing.T) {
+	group, ctx := NewErrorGroup(context.Background(), FailFast)
+	group.Go("ok", func(ctx context.Context) error { return nil })
+	assert.NoError(t, group.Wait())
+	assert.Error(t, ctx.Err())
+}
@@ -39,45 +39,28 @@ func ErrorHandlingThatIsNotAbleToPropagateValues(urls ...string) {
 
 	for resp := range checkStatus(done, urls...) {
 		fmt.Printf("Response for %v: %d\n", resp.Request.URL, resp.StatusCode)
+		resp.Body.Close()
 	}
 }
 
-type Result struct {
-	Error    error
-	Response *http.Response
-	Url      string
-}
-
 // ErrorHandlingThatIsAbleToPropagateValues function fetches http responses and
 // is able to pass/communicate an error event as it emits a channel of result values
 func ErrorHandlingThatIsAbleToPropagateValues(urls ...string) error {
 	done := make(chan interface{})
 	defer close(done)
 
-	checkStatus := func(done <-chan interface{}, urls ...string) <-chan Result {
-		results := make(chan Result)
-		go func() {
-			defer close(results)
-			// make request
-			for _, url := range urls {
-				resp, err := http.Get(url)
-				result := Result{Error: err, Response: resp, Url: url}
-				select {
-				case <-done:
-					return
-				case results <- result:
-				}
-			}
-		}()
-		return results
+	checkStatus := func(url string) (*http.Response, Meta, error) {
+		resp, err := http.Get(url)
+		return resp, Meta{"url": url}, err
 	}
 
-	for res := range checkStatus(done, urls...) {
-		if res.Error != nil {
+	for res := range Generate(done, checkStatus, urls...) {
+		if res.Err != nil {
 			// we can take an informed decision about the error now
-			return errors.New(fmt.Sprintf("[Informed] Error processing request %v: %v", res.Url, res.Error))
+			return errors.New(fmt.Sprintf("[Informed] Error processing request %v: %v", res.Meta["url"], res.Err))
 		}
-		fmt.Printf("Response for %v: %d\n", res.Url, res.Response.StatusCode)
+		fmt.Printf("Response for %v: %d\n", res.Meta["url"], res.Value.StatusCode)
+		res.Value.Body.Close()
 	}
 	return nil
 }
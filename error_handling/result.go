@@ -0,0 +1,138 @@
+package error_handling
+
+import "sync"
+
+// Zen: A result couples a value with the error that may have come in its place, so that errors
+// flow down the same channels as values and reach the goroutine that knows what to do with them.
+// Being generic, the same result travels through generators, pipeline stages and fan-out alike,
+// and the stages below only need to know what to do with a value, not with an error.
+
+// Meta carries the context a result was produced in, such as the url that was fetched
+type Meta map[string]string
+
+// Result is either a value or the error that was raised in its place, along with its context
+type Result[T any] struct {
+	Value T
+	Err   error
+	Meta  Meta
+}
+
+// Ok reports whether the result carries a value rather than an error
+func (r Result[T]) Ok() bool {
+	return r.Err == nil
+}
+
+// Generate emits the result of fn for every input, until the inputs are exhausted or done
+// is closed. The meta of every result is the one returned by fn.
+func Generate[In, T any](done <-chan interface{}, fn func(In) (T, Meta, error), inputs ...In) <-chan Result[T] {
+	results := make(chan Result[T])
+	go func() {
+		defer close(results)
+		for _, in := range inputs {
+			v, meta, err := fn(in)
+			select {
+			case <-done:
+				return
+			case results <- Result[T]{Value: v, Err: err, Meta: meta}:
+			}
+		}
+	}()
+	return results
+}
+
+// Map is a pipeline stage that applies fn to the value of every result. Errors pass through
+// untouched, as do the metas, so that a failure upstream reaches the consumer as it was raised.
+// An error returned by fn takes the place of the value.
+func Map[T, U any](done <-chan interface{}, in <-chan Result[T], fn func(T) (U, error)) <-chan Result[U] {
+	out := make(chan Result[U])
+	go func() {
+		defer close(out)
+		for r := range in {
+			mapped := Result[U]{Err: r.Err, Meta: r.Meta}
+			if r.Err == nil {
+				mapped.Value, mapped.Err = fn(r.Value)
+			}
+			select {
+			case <-done:
+				return
+			case out <- mapped:
+			}
+		}
+	}()
+	return out
+}
+
+// Split separates a result stream into its values and its failed results. Both channels must
+// be drained, as a result waiting on one of them holds up the other.
+func Split[T any](done <-chan interface{}, in <-chan Result[T]) (<-chan T, <-chan Result[T]) {
+	values := make(chan T)
+	failures := make(chan Result[T])
+	go func() {
+		defer close(values)
+		defer close(failures)
+		for r := range in {
+			if r.Err != nil {
+				select {
+				case <-done:
+					return
+				case failures <- r:
+				}
+				continue
+			}
+			select {
+			case <-done:
+				return
+			case values <- r.Value:
+			}
+		}
+	}()
+	return values, failures
+}
+
+// UntilError emits the values of a result stream up to its first error. The error, if any,
+// can be read from the second channel once the values are closed, and nothing is read past it.
+func UntilError[T any](done <-chan interface{}, in <-chan Result[T]) (<-chan T, <-chan error) {
+	values := make(chan T)
+	// buffered, so that the error is there to be read whenever the caller is done with values
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		defer close(values)
+		for r := range in {
+			if r.Err != nil {
+				errCh <- r.Err
+				return
+			}
+			select {
+			case <-done:
+				return
+			case values <- r.Value:
+			}
+		}
+	}()
+	return values, errCh
+}
+
+// Merge fans in multiple result streams into one, errors included
+func Merge[T any](done <-chan interface{}, streams ...<-chan Result[T]) <-chan Result[T] {
+	merged := make(chan Result[T])
+	var wg sync.WaitGroup
+	wg.Add(len(streams))
+	for _, s := range streams {
+		go func(s <-chan Result[T]) {
+			defer wg.Done()
+			for r := range s {
+				select {
+				case <-done:
+					return
+				case merged <- r:
+				}
+			}
+		}(s)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+	return merged
+}
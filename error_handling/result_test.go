@@ -0,0 +1,106 @@
+package error_handling
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errOdd = errors.New("odd")
+
+// evens fails on odd numbers, tagging every result with the number it was produced for
+func evens(done <-chan interface{}, nums ...int) <-chan Result[int] {
+	return Generate(done, func(n int) (int, Meta, error) {
+		meta := Meta{"n": strconv.Itoa(n)}
+		if n%2 != 0 {
+			return 0, meta, errOdd
+		}
+		return n, meta, nil
+	}, nums...)
+}
+
+func TestMapPassesErrorsThrough(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	var mapped int
+	results := Map(done, evens(done, 2, 3, 4), func(n int) (string, error) {
+		mapped++
+		return strconv.Itoa(n * 10), nil
+	})
+	var out []Result[string]
+	for r := range results {
+		out = append(out, r)
+	}
+	assert.Equal(t, []Result[string]{
+		{Value: "20", Meta: Meta{"n": "2"}},
+		{Err: errOdd, Meta: Meta{"n": "3"}},
+		{Value: "40", Meta: Meta{"n": "4"}},
+	}, out)
+	// the stage never sees the failed result
+	assert.Equal(t, 2, mapped)
+	assert.False(t, out[1].Ok())
+}
+
+func TestSplit(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	values, failures := Split(done, evens(done, 1, 2, 3, 4))
+	var got []int
+	var failed []string
+	for values != nil || failures != nil {
+		select {
+		case v, ok := <-values:
+			if !ok {
+				values = nil
+				continue
+			}
+			got = append(got, v)
+		case r, ok := <-failures:
+			if !ok {
+				failures = nil
+				continue
+			}
+			failed = append(failed, r.Meta["n"])
+		}
+	}
+	assert.Equal(t, []int{2, 4}, got)
+	assert.Equal(t, []string{"1", "3"}, failed)
+}
+
+func TestUntilError(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	values, errCh := UntilError(done, evens(done, 2, 4, 5, 6))
+	var got []int
+	for v := range values {
+		got = append(got, v)
+	}
+	assert.Equal(t, []int{2, 4}, got)
+	assert.ErrorIs(t, <-errCh, errOdd)
+
+	values, errCh = UntilError(done, evens(done, 2, 4))
+	for range values {
+	}
+	assert.NoError(t, <-errCh)
+}
+
+func TestMerge(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	var values, failures int
+	for r := range Merge(done, evens(done, 1, 2), evens(done, 3, 4, 6)) {
+		if r.Ok() {
+			values++
+		} else {
+			failures++
+		}
+	}
+	assert.Equal(t, 3, values)
+	assert.Equal(t, 2, failures)
+}
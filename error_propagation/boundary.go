@@ -0,0 +1,97 @@
+package error_propagation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Zen: runJobClean shows the intent: the user is given a friendly message while the details
+// go to the logs. An error therefore carries two audiences. Its message and diagnostics that
+// are safe are for the user, while its internal detail, its causes and its sensitive diagnostics
+// are only for the logs. At the boundary of the system, anything that isn't a well-formed error
+// is a bug, and the user is only told that it happened along with a log ID to report it with.
+
+// Redacted replaces the value of sensitive diagnostics in anything shown to the user
+const Redacted = "[REDACTED]"
+
+// UserError is what the user gets to see of an error
+type UserError struct {
+	Message string                 `json:"message"`
+	LogID   uint64                 `json:"logId"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// WithDetail sets the internal description of what happened, which only goes to the logs
+func (err CustomError) WithDetail(detail string, args ...interface{}) CustomError {
+	err.Detail = fmt.Sprintf(detail, args...)
+	return err
+}
+
+// With returns a copy of the error with a diagnostic which may be shown to the user. The error
+// it is called on is left untouched, so that it can be shared across goroutines.
+func (err CustomError) With(key string, value interface{}) CustomError {
+	misc := make(map[string]interface{}, len(err.Misc)+1)
+	for k, v := range err.Misc {
+		misc[k] = v
+	}
+	misc[key] = value
+	err.Misc = misc
+	return err
+}
+
+// WithSensitive is With for a diagnostic which is redacted from anything shown to the user
+func (err CustomError) WithSensitive(key string, value interface{}) CustomError {
+	err = err.With(key, value)
+	sensitive := make(map[string]bool, len(err.sensitive)+1)
+	for k := range err.sensitive {
+		sensitive[k] = true
+	}
+	sensitive[key] = true
+	err.sensitive = sensitive
+	return err
+}
+
+// IsSensitive reports whether the diagnostic with the given key must not be shown to the user
+func (err CustomError) IsSensitive(key string) bool {
+	return err.sensitive[key]
+}
+
+// Redact returns the diagnostics of the error that are fit to be shown to the user, with the
+// values of sensitive ones replaced
+func (err CustomError) Redact() map[string]interface{} {
+	if len(err.Misc) == 0 {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(err.Misc))
+	for k, v := range err.Misc {
+		if err.IsSensitive(k) {
+			v = Redacted
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// Boundary turns err into what the user gets to see, and writes its full report to the logs.
+// Only a well-formed error keeps its message and its redacted diagnostics. Anything else is
+// unexpected, for which the user is only given the log ID under which it was reported.
+func Boundary(err error) UserError {
+	report := NewReport(err)
+	if report.LogID == 0 {
+		// a raw error never got an ID, but the user still needs one to report it with
+		report.LogID = newLogID()
+	}
+	encoded, _ := json.Marshal(report)
+	log.Printf("%s", encoded)
+
+	if IsWellFormed(err) {
+		var high HighLevelErr
+		errors.As(err, &high)
+		var custom CustomError
+		errors.As(high.error, &custom)
+		return UserError{Message: custom.Message, LogID: report.LogID, Details: custom.Redact()}
+	}
+	return UserError{Message: fmt.Sprintf("unexpected error, log ID %d", report.LogID), LogID: report.LogID}
+}
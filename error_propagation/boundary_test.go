@@ -0,0 +1,68 @@
+package error_propagation
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoundaryWellFormedError(t *testing.T) {
+	custom := wrapError(NewLowLevelErr(errors.New("401 from vault"), "cannot fetch secret"), "could not log you in").
+		WithDetail("vault rejected token for user %d", 42).
+		With("user", 42).
+		WithSensitive("token", "s3cr3t")
+	err := HighLevelErr{custom}
+
+	user := Boundary(err)
+	assert.Equal(t, "could not log you in", user.Message)
+	assert.Equal(t, custom.LogID, user.LogID)
+	assert.Equal(t, map[string]interface{}{"user": 42, "token": Redacted}, user.Details)
+	// the user output never carries the sensitive value, nor the internal detail
+	assert.NotContains(t, fmt.Sprintf("%+v", user), "s3cr3t")
+	assert.NotContains(t, fmt.Sprintf("%+v", user), "vault")
+
+	// while the logs have all of it
+	report := NewReport(err)
+	assert.Equal(t, "vault rejected token for user 42", report.Detail)
+	assert.Equal(t, "s3cr3t", report.Misc["token"])
+	assert.Equal(t, []string{"token"}, report.Sensitive)
+}
+
+func TestBoundaryUnexpectedError(t *testing.T) {
+	// the low level error escaped the high level module as is
+	err := runJobNotClean("bin123")
+	user := Boundary(err)
+	var low CustomError
+	assert.True(t, errors.As(err, &low))
+	assert.Equal(t, fmt.Sprintf("unexpected error, log ID %d", low.LogID), user.Message)
+	assert.Nil(t, user.Details)
+
+	// raw errors are given a log ID of their own
+	user = Boundary(errors.New("connection reset by peer"))
+	assert.NotZero(t, user.LogID)
+	assert.Equal(t, fmt.Sprintf("unexpected error, log ID %d", user.LogID), user.Message)
+}
+
+func TestRedact(t *testing.T) {
+	err := wrapError(nil, "oops")
+	assert.Nil(t, err.Redact())
+	assert.False(t, err.IsSensitive("password"))
+
+	err = CustomError{Message: "built by hand"}.WithSensitive("password", "hunter2")
+	assert.True(t, err.IsSensitive("password"))
+	assert.Equal(t, map[string]interface{}{"password": Redacted}, err.Redact())
+	assert.Equal(t, "hunter2", err.Misc["password"])
+}
+
+func TestWithLeavesOriginalUntouched(t *testing.T) {
+	base := wrapError(nil, "oops").With("user", "gopher")
+	withToken := base.WithSensitive("token", "s3cr3t")
+	withRegion := base.With("region", "eu")
+
+	assert.Equal(t, map[string]interface{}{"user": "gopher"}, base.Misc)
+	assert.False(t, base.IsSensitive("token"))
+	assert.Equal(t, map[string]interface{}{"user": "gopher", "token": Redacted}, withToken.Redact())
+	assert.Equal(t, map[string]interface{}{"user": "gopher", "region": "eu"}, withRegion.Misc)
+}
@@ -1,10 +1,24 @@
 package error_propagation
 
 import (
+	"errors"
 	"fmt"
-	"runtime/debug"
+	"math/rand"
+	"runtime"
+	"strings"
 )
 
+// newLogID returns a random log ID, so that IDs handed out by different processes, or by the same
+// one before and after a restart, don't collide in the logs they all end up in. The functions of
+// math/rand are seeded at random from Go 1.20 on. Zero is left out, as it stands for no ID.
+func newLogID() uint64 {
+	for {
+		if id := rand.Uint64(); id != 0 {
+			return id
+		}
+	}
+}
+
 // CustomError is a wrapper. An ideal system error should let us answer the following
 // 	- What happened
 // 	- When and where it happened
@@ -13,23 +27,68 @@ import (
 type CustomError struct {
 	// Inner captures the underlying error
 	Inner error
-	// Message is a human-readable message
+	// Message is a human-readable message, safe to be shown to the user
 	Message string
-	// Stacktrace when the error was created
-	Stacktrace string
+	// Detail is an internal description of what happened, meant for the logs only
+	Detail string
+	// stack holds the program counters of the calls when the error was created. These are
+	// only formatted on demand, and only the deepest error of a chain keeps them
+	stack []uintptr
 	// Misc is a catch-all bag for storing other useful diagnostics for the underlying error
 	Misc map[string]interface{}
+	// sensitive holds the keys of Misc which must never be shown to the user
+	sensitive map[string]bool
+	// LogID uniquely identifies the error, so that the user can reference the full error log
+	LogID uint64
 }
 
 func (err CustomError) Error() string {
 	return err.Message
 }
 
+// Unwrap returns the underlying error, so that errors.Is and errors.As can see through
+func (err CustomError) Unwrap() error {
+	return err.Inner
+}
+
+// Stacktrace formats the stack when the error was created, or returns an empty string if the
+// error wraps another CustomError, which holds the stack instead
+func (err CustomError) Stacktrace() string {
+	var b strings.Builder
+	for _, f := range err.Frames() {
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", f.Function, f.File, f.Line)
+	}
+	return b.String()
+}
+
+// maxStackDepth is the number of calls captured for an error, deeper ones are left out
+const maxStackDepth = 32
+
 func wrapError(err error, message string, msgArgs ...interface{}) CustomError {
-	return CustomError{
-		Inner:      err,
-		Message:    fmt.Sprintf(message, msgArgs...),
-		Stacktrace: string(debug.Stack()),
-		Misc:       make(map[string]interface{}),
+	wrapped := CustomError{
+		Inner:     err,
+		Message:   fmt.Sprintf(message, msgArgs...),
+		Misc:      make(map[string]interface{}),
+		sensitive: make(map[string]bool),
+		LogID:     newLogID(),
+	}
+	// the stack of the deepest error already tells where it all started, capturing it again at
+	// every layer would only repeat it. Errors in between hold none, so the whole chain is looked at
+	if hasStack(err) {
+		return wrapped
+	}
+	pcs := make([]uintptr, maxStackDepth)
+	// skip runtime.Callers and wrapError itself
+	wrapped.stack = pcs[:runtime.Callers(2, pcs)]
+	return wrapped
+}
+
+// hasStack reports whether any CustomError in the chain of err holds a stack
+func hasStack(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if custom, ok := err.(CustomError); ok && len(custom.stack) > 0 {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,69 @@
+package error_propagation
+
+import (
+	"errors"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOnlyDeepestErrorKeepsStack(t *testing.T) {
+	err := runJobClean("bin123").(HighLevelErr)
+	high := err.error.(CustomError)
+	low := high.Inner.(LowLevelErr).error.(CustomError)
+
+	assert.Empty(t, high.Stacktrace())
+	assert.True(t, strings.HasPrefix(low.Stacktrace(), "patterns/error_propagation.isGloballyExecutable\n\t"))
+	assert.Contains(t, low.Stacktrace(), "low_level_module.go:")
+	assert.Contains(t, low.Stacktrace(), "patterns/error_propagation.runJobClean\n\t")
+}
+
+func TestOnlyDeepestErrorKeepsStackAcrossManyLayers(t *testing.T) {
+	root := errors.New("root")
+	low := wrapError(root, "low")
+	mid := wrapError(low, "mid")
+	high := wrapError(mid, "high")
+	top := wrapError(high, "top")
+
+	assert.NotEmpty(t, low.Stacktrace())
+	for _, err := range []CustomError{mid, high, top} {
+		assert.Empty(t, err.Stacktrace(), err.Message)
+	}
+}
+
+// legacyError captures its stack the way wrapError used to, for benchmarks to compare against
+type legacyError struct {
+	inner      error
+	message    string
+	stacktrace string
+}
+
+func (err legacyError) Error() string {
+	return err.message
+}
+
+func wrapLegacy(err error, message string) error {
+	return legacyError{inner: err, message: message, stacktrace: string(debug.Stack())}
+}
+
+var benchmarkErr error
+
+// BenchmarkWrapErrorLegacy wraps an error through three layers, formatting the whole stack
+// into a string at every one of them
+func BenchmarkWrapErrorLegacy(b *testing.B) {
+	root := errors.New("root")
+	for i := 0; i < b.N; i++ {
+		benchmarkErr = wrapLegacy(wrapLegacy(wrapLegacy(root, "low"), "mid"), "high")
+	}
+}
+
+// BenchmarkWrapError wraps an error through three layers, capturing only the program counters
+// of the deepest one
+func BenchmarkWrapError(b *testing.B) {
+	root := errors.New("root")
+	for i := 0; i < b.N; i++ {
+		benchmarkErr = wrapError(wrapError(wrapError(root, "low"), "mid"), "high")
+	}
+}
@@ -1,6 +1,7 @@
 package error_propagation
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 )
@@ -9,6 +10,10 @@ type HighLevelErr struct {
 	error
 }
 
+func (err HighLevelErr) Unwrap() error {
+	return err.error
+}
+
 func runJobNotClean(id string) error {
 	executable, err := isGloballyExecutable(id)
 	if err != nil {
@@ -25,7 +30,7 @@ func runJobClean(id string) error {
 	executable, err := isGloballyExecutable(id)
 	if err != nil {
 		// clean as we  wrap the low level error here
-		return HighLevelErr{wrapError(err, "could not find binary %v", id)}
+		return NewHighLevelErr(err, "could not find binary %v", id)
 	}
 	if !executable {
 		return wrapError(nil, "binary not executable")
@@ -35,8 +40,9 @@ func runJobClean(id string) error {
 
 func handleError(key int, err error, message string) {
 	log.SetPrefix(fmt.Sprintf("[logId: %v", key))
-	// notice how complete errors are logged
-	log.Printf("%#v", err)
+	// notice how complete errors are logged, in a form that the log pipeline can index
+	report, _ := json.Marshal(NewReport(err))
+	log.Printf("%s", report)
 	// notice how only the user-friendly message is written to out
 	fmt.Printf("[%v] %v\n", key, message)
 }
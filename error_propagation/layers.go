@@ -0,0 +1,65 @@
+package error_propagation
+
+import "errors"
+
+// Zen: Errors cross a module boundary only after being wrapped by the module that raises them.
+// Each layer wraps the error of the layer below into its own type, and by taking part in Go's
+// error wrapping (Unwrap), the layers remain visible to errors.Is and errors.As. The caller can
+// still check the cause of a high level error while only ever showing its readable message.
+
+// Layer identifies the layer of a module that raised an error
+type Layer int
+
+const (
+	// UnknownLayer is an error that was never wrapped at a module boundary
+	UnknownLayer Layer = iota
+	// LowLevel is an error raised by a low level module, such as one dealing with the OS
+	LowLevel
+	// HighLevel is an error raised by a high level module, ready to be shown to the user
+	HighLevel
+)
+
+func (l Layer) String() string {
+	switch l {
+	case LowLevel:
+		return "low"
+	case HighLevel:
+		return "high"
+	}
+	return "unknown"
+}
+
+// NewLowLevelErr wraps err at the boundary of a low level module
+func NewLowLevelErr(err error, message string, msgArgs ...interface{}) LowLevelErr {
+	return LowLevelErr{wrapError(err, message, msgArgs...)}
+}
+
+// NewHighLevelErr wraps err at the boundary of a high level module
+func NewHighLevelErr(err error, message string, msgArgs ...interface{}) HighLevelErr {
+	return HighLevelErr{wrapError(err, message, msgArgs...)}
+}
+
+// LayerOf returns the outermost layer that wrapped err
+func LayerOf(err error) Layer {
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch err.(type) {
+		case HighLevelErr:
+			return HighLevel
+		case LowLevelErr:
+			return LowLevel
+		}
+	}
+	return UnknownLayer
+}
+
+// IsWellFormed reports whether err was wrapped at the boundary of a high level module into a
+// CustomError. Only such errors carry a message fit for the user, anything else is a bug in
+// the module which let a raw error escape.
+func IsWellFormed(err error) bool {
+	var high HighLevelErr
+	if !errors.As(err, &high) {
+		return false
+	}
+	var custom CustomError
+	return errors.As(high.error, &custom)
+}
@@ -0,0 +1,40 @@
+package error_propagation
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLayersTakePartInWrapping(t *testing.T) {
+	err := runJobClean("bin123")
+
+	// the cause is visible through both the high and the low level wrappers
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	var pathErr *fs.PathError
+	assert.True(t, errors.As(err, &pathErr))
+	assert.Equal(t, "bin123", pathErr.Path)
+
+	var low LowLevelErr
+	assert.True(t, errors.As(err, &low))
+	assert.Equal(t, "stat bin123: no such file or directory", low.Error())
+	assert.Equal(t, LowLevel, LayerOf(low))
+	assert.Equal(t, HighLevel, LayerOf(err))
+	assert.Equal(t, "could not find binary bin123", err.Error())
+}
+
+func TestIsWellFormed(t *testing.T) {
+	assert.True(t, IsWellFormed(runJobClean("bin123")))
+
+	// the low level error escaped the high level module as is
+	err := runJobNotClean("bin123")
+	assert.False(t, IsWellFormed(err))
+	assert.Equal(t, LowLevel, LayerOf(err))
+
+	assert.False(t, IsWellFormed(HighLevelErr{errors.New("raw")}))
+	assert.False(t, IsWellFormed(wrapError(nil, "never crossed a boundary")))
+	assert.Equal(t, UnknownLayer, LayerOf(errors.New("raw")))
+	assert.Equal(t, "high", HighLevel.String())
+}
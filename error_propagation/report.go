@@ -0,0 +1,179 @@
+package error_propagation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Zen: The full error is meant for the logs, but dumping it with %#v makes for a huge struct
+// that no log pipeline can make sense of. A report flattens the error into what is worth
+// indexing: the layer that raised it, its message, the chain of causes below it, the stack
+// of where it all started and the diagnostics gathered along the way.
+
+// Report is the machine-readable form of an error, as written to the logs
+type Report struct {
+	// LogID is the ID of the outermost CustomError, which the user is given as a reference
+	LogID uint64 `json:"logId,omitempty"`
+	// Layer is the outermost layer that wrapped the error
+	Layer string `json:"layer"`
+	// Message is the message of the error
+	Message string `json:"message"`
+	// Detail is the internal detail of the outermost CustomError
+	Detail string `json:"detail,omitempty"`
+	// Causes are the errors wrapped by the error, from the outermost to the root cause
+	Causes []Cause `json:"causes,omitempty"`
+	// Stack is the stack where the deepest CustomError was created
+	Stack []Frame `json:"stack,omitempty"`
+	// Misc holds the diagnostics of every CustomError in the chain, the outermost ones winning
+	Misc map[string]interface{} `json:"misc,omitempty"`
+	// Sensitive are the keys of Misc which must not leave the logs
+	Sensitive []string `json:"sensitive,omitempty"`
+}
+
+// Cause is one of the errors in the chain of causes of a Report
+type Cause struct {
+	Layer   string `json:"layer,omitempty"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Detail  string `json:"detail,omitempty"`
+	LogID   uint64 `json:"logId,omitempty"`
+}
+
+// Frame is a function call in a stack trace
+type Frame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// NewReport walks the chain of err and builds its report
+func NewReport(err error) Report {
+	r := Report{Layer: LayerOf(err).String(), Message: err.Error()}
+	layer := UnknownLayer
+	top := true
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		// layer wrappers don't have messages of their own, they tag the error they wrap
+		switch e.(type) {
+		case HighLevelErr:
+			layer = HighLevel
+			continue
+		case LowLevelErr:
+			layer = LowLevel
+			continue
+		}
+		custom, isCustom := e.(CustomError)
+		if isCustom {
+			if r.LogID == 0 {
+				r.LogID, r.Detail = custom.LogID, custom.Detail
+			}
+			if frames := custom.Frames(); len(frames) > 0 {
+				r.Stack = frames
+			}
+			for k, v := range custom.Misc {
+				if r.Misc == nil {
+					r.Misc = make(map[string]interface{})
+				}
+				if _, ok := r.Misc[k]; !ok {
+					r.Misc[k] = v
+					if custom.IsSensitive(k) {
+						r.Sensitive = append(r.Sensitive, k)
+					}
+				}
+			}
+		}
+		if !top {
+			c := Cause{Type: fmt.Sprintf("%T", e), Message: e.Error()}
+			if isCustom {
+				c.Layer, c.Detail, c.LogID = layer.String(), custom.Detail, custom.LogID
+			}
+			r.Causes = append(r.Causes, c)
+		}
+		top, layer = false, UnknownLayer
+	}
+	sort.Strings(r.Sensitive)
+	return r
+}
+
+// Frames resolves the stack of the error into its function calls, leaving out the calls which
+// created the error
+func (err CustomError) Frames() []Frame {
+	if len(err.stack) == 0 {
+		return nil
+	}
+	var frames []Frame
+	callers := runtime.CallersFrames(err.stack)
+	for {
+		f, more := callers.Next()
+		if !isCaptureFrame(f.Function) {
+			frames = append(frames, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		}
+		if !more {
+			return frames
+		}
+	}
+}
+
+// isCaptureFrame reports whether the function is one of those which capture the stack
+func isCaptureFrame(function string) bool {
+	for _, capture := range []string{".wrapError", ".NewLowLevelErr", ".NewHighLevelErr"} {
+		if strings.HasSuffix(function, capture) {
+			return true
+		}
+	}
+	return false
+}
+
+func (err CustomError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(NewReport(err))
+}
+
+func (err LowLevelErr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(NewReport(err))
+}
+
+func (err HighLevelErr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(NewReport(err))
+}
+
+func (err CustomError) Format(s fmt.State, verb rune) {
+	formatError(s, verb, err)
+}
+
+func (err LowLevelErr) Format(s fmt.State, verb rune) {
+	formatError(s, verb, err)
+}
+
+func (err HighLevelErr) Format(s fmt.State, verb rune) {
+	formatError(s, verb, err)
+}
+
+// formatError prints the message of err for %v and %s, while %+v prints its readable cause
+// chain followed by the stack where it all started
+func formatError(s fmt.State, verb rune, err error) {
+	switch {
+	case verb == 'v' && s.Flag('+'):
+		r := NewReport(err)
+		fmt.Fprintf(s, "%s [layer: %s, logId: %d]", r.Message, r.Layer, r.LogID)
+		for _, c := range r.Causes {
+			fmt.Fprintf(s, "\ncaused by: %s (%s", c.Message, c.Type)
+			if c.Layer != "" {
+				fmt.Fprintf(s, ", layer: %s, logId: %d", c.Layer, c.LogID)
+			}
+			_, _ = io.WriteString(s, ")")
+		}
+		for _, f := range r.Stack {
+			fmt.Fprintf(s, "\n\t%s\n\t\t%s:%d", f.Function, f.File, f.Line)
+		}
+	case verb == 'v' || verb == 's':
+		_, _ = io.WriteString(s, err.Error())
+	case verb == 'q':
+		fmt.Fprintf(s, "%q", err.Error())
+	default:
+		fmt.Fprintf(s, "%%!%c(%T)", verb, err)
+	}
+}
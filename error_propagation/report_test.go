@@ -0,0 +1,62 @@
+package error_propagation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportJSON(t *testing.T) {
+	err := runJobClean("bin123")
+	high := err.(HighLevelErr)
+	high.error.(CustomError).Misc["binary"] = "bin123"
+
+	encoded, marshalErr := json.Marshal(err)
+	assert.NoError(t, marshalErr)
+
+	var r Report
+	assert.NoError(t, json.Unmarshal(encoded, &r))
+	assert.Equal(t, "high", r.Layer)
+	assert.Equal(t, "could not find binary bin123", r.Message)
+	assert.Equal(t, high.error.(CustomError).LogID, r.LogID)
+	assert.Equal(t, map[string]interface{}{"binary": "bin123"}, r.Misc)
+
+	assert.Len(t, r.Causes, 3)
+	assert.Equal(t, "low", r.Causes[0].Layer)
+	assert.Equal(t, "error_propagation.CustomError", r.Causes[0].Type)
+	assert.Equal(t, "stat bin123: no such file or directory", r.Causes[0].Message)
+	assert.NotZero(t, r.Causes[0].LogID)
+	assert.Equal(t, "*fs.PathError", r.Causes[1].Type)
+	assert.Equal(t, "syscall.Errno", r.Causes[2].Type)
+
+	// the stack is that of the deepest error, starting where the low level module raised it
+	assert.NotEmpty(t, r.Stack)
+	assert.Equal(t, "patterns/error_propagation.isGloballyExecutable", r.Stack[0].Function)
+	assert.True(t, strings.HasSuffix(r.Stack[0].File, "low_level_module.go"))
+	assert.NotZero(t, r.Stack[0].Line)
+}
+
+func TestLogIDsAreUnique(t *testing.T) {
+	a, b := wrapError(nil, "a"), wrapError(nil, "b")
+	assert.NotEqual(t, a.LogID, b.LogID)
+	assert.Equal(t, "unknown", NewReport(a).Layer)
+	assert.Empty(t, NewReport(a).Causes)
+}
+
+func TestFormatCauseChain(t *testing.T) {
+	err := NewHighLevelErr(NewLowLevelErr(errors.New("disk on fire"), "cannot read"), "cannot load config")
+
+	assert.Equal(t, "cannot load config", fmt.Sprintf("%v", err))
+	assert.Equal(t, "cannot load config", fmt.Sprintf("%s", err))
+
+	lines := strings.Split(fmt.Sprintf("%+v", err), "\n")
+	high, low := err.error.(CustomError), err.error.(CustomError).Inner.(LowLevelErr).error.(CustomError)
+	assert.Equal(t, fmt.Sprintf("cannot load config [layer: high, logId: %d]", high.LogID), lines[0])
+	assert.Equal(t, fmt.Sprintf("caused by: cannot read (error_propagation.CustomError, layer: low, logId: %d)", low.LogID), lines[1])
+	assert.Equal(t, "caused by: disk on fire (*errors.errorString)", lines[2])
+	assert.Equal(t, "\tpatterns/error_propagation.TestFormatCauseChain", lines[3])
+}
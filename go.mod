@@ -1,6 +1,6 @@
 module patterns
 
-go 1.16
+go 1.21
 
 require (
 	github.com/stretchr/testify v1.7.0
@@ -8,3 +8,11 @@ require (
 	go.uber.org/goleak v1.1.10
 	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/lint v0.0.0-20190930215403-16217165b5de // indirect
+	golang.org/x/tools v0.0.0-20191108193012-7d206e10da11 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
+)
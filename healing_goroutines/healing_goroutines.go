@@ -3,6 +3,7 @@ package healing_goroutines
 import (
 	"log"
 	"patterns/channel_patterns"
+	"patterns/heartbeats"
 	"time"
 
 	"go.uber.org/atomic"
@@ -35,6 +36,36 @@ func doIrresponsibleWork(done <-chan interface{}, _ time.Duration) <-chan interf
 	return out
 }
 
+// stuckHaltCounter tracks the number of times the stuck ward goroutine is halted
+var stuckHaltCounter atomic.Int64
+
+// doStuckWork keeps pulsing but never progresses past its first item. A steward looking
+// only at the pulses would consider it healthy, but the progress it reports never moves
+func doStuckWork(done <-chan interface{}, pulseInterval time.Duration) <-chan interface{} {
+	heartbeat := make(chan interface{})
+	log.Println("ward: I'm the ward. I am alive, but I can get stuck")
+	go func() {
+		defer close(heartbeat)
+		// pulsing more often than asked ensures that the steward never misses our pulses
+		pulse := time.NewTicker(pulseInterval / 4)
+		defer pulse.Stop()
+		for {
+			select {
+			case <-done:
+				log.Println("ward: Halting")
+				stuckHaltCounter.Add(1)
+				return
+			case <-pulse.C:
+				select {
+				case heartbeat <- heartbeats.Pulse{Processed: 0, At: time.Now(), Status: "stuck"}:
+				default:
+				}
+			}
+		}
+	}()
+	return heartbeat
+}
+
 // startFn is a signature of a function that can be started, closed and monitored
 // It sends heart beats pulses at a duration specified by "pulseInterval"
 type startFn func(done <-chan interface{}, pulseInterval time.Duration) (heartbeat <-chan interface{})
@@ -43,6 +74,13 @@ type startFn func(done <-chan interface{}, pulseInterval time.Duration) (heartbe
 // If the ward doesn't reply with a healthy heartbeat to the steward, it will time out and
 // restart the goroutine. The steward itself returns a startFn, so it can be monitored too.
 func newSteward(timeout time.Duration, f startFn) startFn {
+	return newProgressSteward(timeout, 0, f)
+}
+
+// newProgressSteward is the same as newSteward, but if the ward pulses with a heartbeats.Pulse
+// payload, it also restarts the ward once stallAfter pulses in a row show no progress.
+// A ward that is alive but stuck would otherwise be considered healthy forever.
+func newProgressSteward(timeout time.Duration, stallAfter int, f startFn) startFn {
 	return func(done <-chan interface{}, pulseInterval time.Duration) <-chan interface{} {
 		heartbeat := make(chan interface{})
 		go func() {
@@ -59,6 +97,7 @@ func newSteward(timeout time.Duration, f startFn) startFn {
 			}
 			startWard()
 			pulse := time.Tick(pulseInterval)
+			stalls := heartbeats.NewStallDetector(stallAfter)
 
 		monitorLoop:
 			for { // forever monitoring loop
@@ -70,12 +109,21 @@ func newSteward(timeout time.Duration, f startFn) startFn {
 						case heartbeat <- struct{}{}: // steward is healthy
 						default:
 						}
-					case <-wardHeartbeat:
+					case beat := <-wardHeartbeat:
+						// bare heartbeats carry no progress, so only a pulse payload can be stuck
+						if p, ok := beat.(heartbeats.Pulse); ok && stalls.Observe(p) {
+							log.Println("steward: ward alive but stuck, restarting")
+							close(wardDone)
+							stalls.Reset()
+							startWard()
+							continue monitorLoop
+						}
 						log.Println("steward: got ward heartbeat, ")
 						continue monitorLoop
 					case <-timeoutSignal:
 						log.Println("steward: ward unhealthy, restarting")
 						close(wardDone) // tell ward to stop since it's not behaving properly
+						stalls.Reset()
 						startWard()
 						continue monitorLoop
 					case <-done:
@@ -48,3 +48,34 @@ func TestIrresponsibleWardWithSteward(t *testing.T) {
 	}
 	assert.Equal(t, "3", haltCounter.String())
 }
+
+func TestStuckWardWithSteward(t *testing.T) {
+	done := make(chan interface{})
+	mainDuration := 2 * time.Second
+	monitorDuration := 400 * time.Millisecond
+	stuckHaltCounter.Store(0)
+
+	// the ward pulses well within 400ms, so a plain steward never times out on it
+	workWithSteward := newSteward(monitorDuration, doStuckWork)
+	time.AfterFunc(mainDuration, func() { close(done) })
+
+	for range workWithSteward(done, monitorDuration) {
+	}
+	assert.Equal(t, "1", stuckHaltCounter.String()) // halted only when main gave up
+}
+
+func TestStuckWardWithProgressSteward(t *testing.T) {
+	done := make(chan interface{})
+	mainDuration := 2 * time.Second
+	monitorDuration := 400 * time.Millisecond
+	stuckHaltCounter.Store(0)
+
+	// here the steward looks into the pulses and restarts the ward once it sees that
+	// four pulses in a row have not made any progress
+	workWithSteward := newProgressSteward(monitorDuration, 4, doStuckWork)
+	time.AfterFunc(mainDuration, func() { close(done) })
+
+	for range workWithSteward(done, monitorDuration) {
+	}
+	assert.GreaterOrEqual(t, stuckHaltCounter.Load(), int64(2))
+}
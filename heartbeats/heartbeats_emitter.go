@@ -0,0 +1,160 @@
+package heartbeats
+
+import (
+	"patterns/clock"
+	"time"
+)
+
+// Zen: Whether a producer pulses at the beginning of every unit of work or on a timed interval
+// is a choice it should make once, and not something it should hand roll at every select.
+// An emitter owns the heartbeat channel and the pulse ticker of a producer, so that the same
+// producer can pulse per item, on an interval or both. The only hard rule carries over from
+// HeartbeatAndResult: pulses on an interval must keep firing while the producer is blocked.
+
+// Mode decides when an Emitter sends out its pulses
+type Mode int
+
+const (
+	// Interval pulses on a fixed interval, including while the producer is blocked
+	Interval Mode = 1 << iota
+	// PerItem pulses at the beginning of every unit of work
+	PerItem
+	// Both pulses at the beginning of every unit of work as well as on a fixed interval
+	Both = Interval | PerItem
+)
+
+// Emitter sends out a producer's heartbeats according to its Mode. Pulses carry a Pulse payload
+// so that listeners can also tell how far along the producer is. An emitter is owned by the
+// single producer goroutine that emits through it, which must Close it when it returns.
+type Emitter struct {
+	mode      Mode
+	clock     clock.Clock
+	heartbeat chan interface{}
+	ticker    clock.Ticker
+	// tick is nil when not pulsing on an interval, which blocks forever in a select
+	tick      <-chan time.Time
+	processed int64
+}
+
+// NewEmitter returns an emitter for the given mode. The pulse interval is ignored unless
+// the mode includes Interval.
+func NewEmitter(mode Mode, pulseInterval time.Duration) *Emitter {
+	return NewEmitterWithClock(clock.New(), mode, pulseInterval)
+}
+
+// NewEmitterWithClock is same as NewEmitter but pulses on the given clock
+func NewEmitterWithClock(clk clock.Clock, mode Mode, pulseInterval time.Duration) *Emitter {
+	e := &Emitter{
+		mode:  mode,
+		clock: clk,
+		// ensure at least one pulse is kept even if no one is listening in time for it
+		heartbeat: make(chan interface{}, 1),
+	}
+	if mode&Interval != 0 {
+		e.ticker = clk.NewTicker(pulseInterval)
+		e.tick = e.ticker.C()
+	}
+	return e
+}
+
+// Heartbeat returns the channel on which pulses are sent out. It is closed by Close.
+func (e *Emitter) Heartbeat() <-chan interface{} {
+	return e.heartbeat
+}
+
+// Ticks returns the interval ticker for producers that need to select on it themselves,
+// who must then call Pulse, or PulseBlocked, when it fires. It is nil when the mode doesn't include Interval.
+func (e *Emitter) Ticks() <-chan time.Time {
+	return e.tick
+}
+
+// Begin marks the beginning of a unit of work and pulses if the mode includes PerItem
+func (e *Emitter) Begin() {
+	if e.mode&PerItem != 0 {
+		e.Pulse("working")
+	}
+}
+
+// Pulse sends out a pulse with the given status unless no one is listening
+func (e *Emitter) Pulse(status string) {
+	e.pulse(status, false)
+}
+
+// PulseBlocked is Pulse for producers blocked on another goroutine, see Pulse.Blocked
+func (e *Emitter) PulseBlocked(status string) {
+	e.pulse(status, true)
+}
+
+func (e *Emitter) pulse(status string, blocked bool) {
+	sendPulseWith(e.heartbeat, Pulse{
+		Processed: e.processed,
+		At:        e.clock.Now(),
+		Status:    status,
+		Blocked:   blocked,
+	})
+}
+
+// Close stops the interval ticker and closes the heartbeat channel
+func (e *Emitter) Close() {
+	if e.ticker != nil {
+		e.ticker.Stop()
+	}
+	close(e.heartbeat)
+}
+
+// Send sends the result of a unit of work on out, pulsing on the interval while it waits for
+// someone to take it. It returns false if done was closed before the result could be sent.
+func Send[T any](done <-chan interface{}, e *Emitter, out chan<- T, v T) bool {
+	for {
+		select {
+		case <-done:
+			return false
+		case <-e.tick:
+			e.PulseBlocked("sending")
+		case out <- v:
+			e.processed++
+			return true
+		}
+	}
+}
+
+// Receive waits for the next unit of work on in, pulsing on the interval while it waits.
+// It returns false if done was closed or in was closed before a value arrived.
+func Receive[T any](done <-chan interface{}, e *Emitter, in <-chan T) (T, bool) {
+	for {
+		select {
+		case <-done:
+			var zero T
+			return zero, false
+		case <-e.tick:
+			e.PulseBlocked("waiting")
+		case v, ok := <-in:
+			return v, ok
+		}
+	}
+}
+
+// HeartbeatWorkStream applies work to each of the given nums and streams the results, pulsing
+// according to the given mode. It stands for a long job made of many units of work, which
+// listeners monitor through pulses regardless of how long each unit takes to get consumed.
+func HeartbeatWorkStream(done <-chan interface{}, mode Mode, pulseInterval time.Duration, work func(int) int, nums ...int) (<-chan interface{}, <-chan int) {
+	return HeartbeatWorkStreamWithClock(done, clock.New(), mode, pulseInterval, work, nums...)
+}
+
+// HeartbeatWorkStreamWithClock is same as HeartbeatWorkStream but pulses on the given clock
+func HeartbeatWorkStreamWithClock(done <-chan interface{}, clk clock.Clock, mode Mode, pulseInterval time.Duration, work func(int) int, nums ...int) (<-chan interface{}, <-chan int) {
+	emitter := NewEmitterWithClock(clk, mode, pulseInterval)
+	intStream := make(chan int)
+
+	go func() {
+		defer emitter.Close()
+		defer close(intStream)
+		for _, n := range nums {
+			emitter.Begin()
+			if !Send(done, emitter, intStream, work(n)) {
+				return
+			}
+		}
+	}()
+	return emitter.Heartbeat(), intStream
+}
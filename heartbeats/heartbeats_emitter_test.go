@@ -0,0 +1,91 @@
+package heartbeats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func double(n int) int { return n * 2 }
+
+func TestHeartbeatWorkStreamPerItem(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+	ints := []int{0, 1, 2, 3, 5}
+
+	pulses, intStream := HeartbeatWorkStream(done, PerItem, time.Hour, double, ints...)
+	for i, expected := range ints {
+		// every unit of work is preceded by exactly one pulse carrying its progress
+		p := (<-pulses).(Pulse)
+		assert.Equal(t, int64(i), p.Processed)
+		assert.Equal(t, "working", p.Status)
+		assert.Equal(t, double(expected), <-intStream)
+	}
+	_, ok := <-intStream
+	assert.False(t, ok)
+	_, ok = <-pulses
+	assert.False(t, ok)
+}
+
+// TestHeartbeatWorkStreamIntervalWhileBlocked checks that interval pulses keep going out
+// while nobody takes the result, which is what sendWorkResult does by hand
+func TestHeartbeatWorkStreamIntervalWhileBlocked(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	pulses, intStream := HeartbeatWorkStream(done, Interval, time.Millisecond, double, 1, 2)
+	for i := 0; i < 3; i++ {
+		p := (<-pulses).(Pulse)
+		assert.Equal(t, int64(0), p.Processed)
+		assert.Equal(t, "sending", p.Status)
+		// a slow consumer doesn't make the producer look stuck
+		assert.True(t, p.Blocked)
+	}
+	assert.Equal(t, 2, <-intStream)
+	assert.Equal(t, 4, <-intStream)
+}
+
+func TestHeartbeatWorkStreamBoth(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	pulses, intStream := HeartbeatWorkStream(done, Both, time.Millisecond, double, 1)
+	statuses := map[string]bool{}
+	for len(statuses) < 2 {
+		statuses[(<-pulses).(Pulse).Status] = true
+	}
+	assert.True(t, statuses["working"])
+	assert.True(t, statuses["sending"])
+	assert.Equal(t, 2, <-intStream)
+}
+
+func TestReceivePulsesWhileWaiting(t *testing.T) {
+	done := make(chan interface{})
+	in := make(chan int)
+	emitter := NewEmitter(Interval, time.Millisecond)
+	defer emitter.Close()
+
+	received := make(chan int)
+	go func() {
+		defer close(received)
+		if v, ok := Receive(done, emitter, in); ok {
+			received <- v
+		}
+	}()
+	p := (<-emitter.Heartbeat()).(Pulse)
+	assert.Equal(t, "waiting", p.Status)
+	assert.True(t, p.Blocked)
+	in <- 7
+	assert.Equal(t, 7, <-received)
+
+	go func() { close(done) }()
+	_, ok := Receive(done, emitter, in)
+	assert.False(t, ok)
+}
+
+func TestEmitterWithoutInterval(t *testing.T) {
+	emitter := NewEmitter(PerItem, time.Millisecond)
+	assert.Nil(t, emitter.Ticks())
+	emitter.Close()
+}
@@ -0,0 +1,70 @@
+package heartbeats
+
+import (
+	"patterns/clock"
+	"patterns/heartbeats/heartbeattest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the virtual clock means that the interval can be as generous as we like
+const virtualInterval = time.Minute
+
+var harnessOptions = heartbeattest.Options{Interval: virtualInterval}
+
+func heartbeatAndResult(done <-chan interface{}, clk clock.Clock) (<-chan interface{}, <-chan time.Time) {
+	return HeartbeatAndResultWithClock(done, clk, virtualInterval)
+}
+
+func heartbeatAndResultFaulty(done <-chan interface{}, clk clock.Clock) (<-chan interface{}, <-chan time.Time) {
+	return HeartbeatAndResultFaultyWithClock(done, clk, virtualInterval)
+}
+
+// TestHeartbeatWithResultDeterministic is same as TestHeartbeatWithResult, but instead of
+// waiting for the wall clock, it moves a virtual one and checks every pulse and result
+func TestHeartbeatWithResultDeterministic(t *testing.T) {
+	h := heartbeattest.Run(t, harnessOptions, heartbeatAndResult)
+	start := h.Clock().Now()
+
+	// a result every two intervals, with a pulse on each of them
+	assert.Equal(t, start.Add(2*virtualInterval), h.ExpectPulsesBetweenResults(2, 2))
+	assert.Equal(t, start.Add(4*virtualInterval), h.ExpectPulsesBetweenResults(2, 2))
+
+	// nobody takes the next result, yet the producer keeps pulsing
+	h.ExpectPulsesWhileBlocked(4)
+	r, _ := h.NextResult(1)
+	assert.Equal(t, start.Add(6*virtualInterval), r)
+}
+
+// TestHeartbeatWithResultUnhealthyIsDetectedDeterministic is same as
+// TestHeartbeatWithResultUnhealthyIsDetected, but detects the fault at the exact interval
+func TestHeartbeatWithResultUnhealthyIsDetectedDeterministic(t *testing.T) {
+	failure := heartbeattest.Failure(func(tb heartbeattest.TB) {
+		h := heartbeattest.Run(tb, harnessOptions, heartbeatAndResultFaulty)
+		for {
+			h.NextResult(4)
+		}
+	})
+	assert.Equal(t, "heartbeattest: producer stopped pulsing: no pulse or result after step 3 (virtual time 3m0s)", failure)
+}
+
+func TestHeartbeatWorkStreamDeterministic(t *testing.T) {
+	double := func(n int) int { return 2 * n }
+	h := heartbeattest.Run(t, harnessOptions, func(done <-chan interface{}, clk clock.Clock) (<-chan interface{}, <-chan int) {
+		return HeartbeatWorkStreamWithClock(done, clk, Interval, virtualInterval, double, 1, 2)
+	})
+
+	// the first result waits for us, the producer pulsing while it is blocked sending it
+	for i := 0; i < 3; i++ {
+		p := h.ExpectPulse().(Pulse)
+		assert.Equal(t, "sending", p.Status)
+		assert.True(t, p.Blocked)
+	}
+	r, pulses := h.NextResult(0)
+	assert.Equal(t, 2, r)
+	assert.Equal(t, 0, pulses)
+	// the next one is ready as soon as it is asked for
+	assert.Equal(t, 4, h.ExpectPulsesBetweenResults(0, 0))
+}
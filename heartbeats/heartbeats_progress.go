@@ -0,0 +1,126 @@
+package heartbeats
+
+import "time"
+
+// Zen: A bare pulse only tells its listeners that a goroutine is alive. It cannot tell apart
+// a goroutine that is alive and progressing from one that is alive but stuck (spinning on the
+// same item, retrying forever, waiting on a lock). If the pulse carries a payload describing
+// the progress made so far, a listener can compare consecutive pulses and flag the stuck one.
+// A producer blocked on a slow consumer makes no progress either, but through no fault of its
+// own, so its pulses say so and are not held against it.
+
+// Pulse is a heartbeat that carries a progress payload along with the signal of life
+type Pulse struct {
+	// Processed is the number of units of work completed so far
+	Processed int64
+	// Offset is the position of the unit of work currently being processed
+	Offset int64
+	// At is the time at which the pulse was emitted
+	At time.Time
+	// Status is a free-form description of what the producer is up to
+	Status string
+	// Blocked is set on pulses sent while the producer waits on another goroutine, such as a
+	// consumer slow to take its output. Such a producer is backpressured, not stuck.
+	Blocked bool
+}
+
+// Progressed reports whether the pulse shows any progress since the previous pulse
+func (p Pulse) Progressed(prev Pulse) bool {
+	return p.Processed != prev.Processed || p.Offset != prev.Offset
+}
+
+// StallDetector watches a sequence of pulses and flags the producer as stuck when a given
+// number of consecutive pulses arrive without any progress. Blocked pulses don't count as
+// stalls, and end a run of them. It is not safe for concurrent use
+// and is meant to be owned by the single goroutine monitoring the producer.
+type StallDetector struct {
+	threshold int
+	stalls    int
+	last      Pulse
+	seen      bool
+}
+
+// NewStallDetector returns a detector that flags a stall after threshold pulses in a row
+// show no progress. A threshold lesser than one disables detection.
+func NewStallDetector(threshold int) *StallDetector {
+	return &StallDetector{threshold: threshold}
+}
+
+// Observe records a pulse and reports whether the producer should be considered stuck
+func (d *StallDetector) Observe(p Pulse) bool {
+	if d.seen && !p.Blocked && !p.Progressed(d.last) {
+		d.stalls++
+	} else {
+		d.stalls = 0
+	}
+	d.last, d.seen = p, true
+	return d.threshold > 0 && d.stalls >= d.threshold
+}
+
+// Reset forgets all pulses seen so far, usually called after the producer is restarted
+func (d *StallDetector) Reset() {
+	d.stalls, d.last, d.seen = 0, Pulse{}, false
+}
+
+// HeartbeatWithProgress is a function that provides a channel which is signalled with a Pulse
+// every pulse interval, along with a stream of the given nums. To simulate a producer that is
+// alive but stuck, it stops progressing at index stuckAt (use a negative value to never get
+// stuck) but keeps on pulsing until done, which a bare heartbeat would not be able to reveal.
+func HeartbeatWithProgress(done <-chan interface{}, pulseInterval time.Duration, stuckAt int, nums ...int) (<-chan interface{}, <-chan int) {
+	heartbeatCh := make(chan interface{})
+	intStream := make(chan int)
+
+	go func() {
+		defer close(heartbeatCh)
+		defer close(intStream)
+		pulse := time.NewTicker(pulseInterval)
+		defer pulse.Stop()
+
+		var processed int64
+		sendProgress := func(offset int, status string, blocked bool) {
+			sendPulseWith(heartbeatCh, Pulse{
+				Processed: processed,
+				Offset:    int64(offset),
+				At:        time.Now(),
+				Status:    status,
+				Blocked:   blocked,
+			})
+		}
+		for i, n := range nums {
+			if i == stuckAt {
+				// we are alive and keep saying so, but we never get past this item
+				for {
+					select {
+					case <-done:
+						return
+					case <-pulse.C:
+						sendProgress(i, "processing", false)
+					}
+				}
+			}
+			// pulses keep going out while we wait for someone to take our result, marked as
+			// blocked, as a slow consumer is no sign of us being stuck
+		send:
+			for {
+				select {
+				case <-done:
+					return
+				case <-pulse.C:
+					sendProgress(i, "sending", true)
+				case intStream <- n:
+					processed++
+					break send
+				}
+			}
+		}
+	}()
+	return heartbeatCh, intStream
+}
+
+// sendPulseWith is the same as sendPulse but carries the given payload
+func sendPulseWith(heartbeatCh chan<- interface{}, payload interface{}) {
+	select {
+	case heartbeatCh <- payload:
+	default:
+	}
+}
@@ -0,0 +1,75 @@
+package heartbeats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStallDetector(t *testing.T) {
+	detector := NewStallDetector(2)
+
+	assert.False(t, detector.Observe(Pulse{Processed: 1}))
+	assert.False(t, detector.Observe(Pulse{Processed: 2}))
+	assert.False(t, detector.Observe(Pulse{Processed: 2})) // first pulse without progress
+	assert.True(t, detector.Observe(Pulse{Processed: 2}))  // second one in a row, we are stuck
+	assert.False(t, detector.Observe(Pulse{Processed: 2, Offset: 1}))
+
+	detector.Reset()
+	assert.False(t, detector.Observe(Pulse{Processed: 2, Offset: 1}))
+	assert.False(t, NewStallDetector(0).Observe(Pulse{}))
+}
+
+func TestStallDetectorIgnoresBlockedPulses(t *testing.T) {
+	detector := NewStallDetector(2)
+
+	assert.False(t, detector.Observe(Pulse{Processed: 1}))
+	// however long a slow consumer takes, a backpressured producer is not stuck
+	for i := 0; i < 5; i++ {
+		assert.False(t, detector.Observe(Pulse{Processed: 1, Blocked: true}))
+	}
+	assert.False(t, detector.Observe(Pulse{Processed: 1}))
+	assert.True(t, detector.Observe(Pulse{Processed: 1}))
+}
+
+// TestHeartbeatWithProgressStuckIsDetected shows that a bare heartbeat would consider the
+// producer healthy as pulses keep arriving, but their payload reveals that it is stuck
+func TestHeartbeatWithProgressStuckIsDetected(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+	const pulseInterval = 10 * time.Millisecond
+
+	pulses, intStream := HeartbeatWithProgress(done, pulseInterval, 2, 0, 1, 2, 3)
+	assert.Equal(t, 0, <-intStream)
+	assert.Equal(t, 1, <-intStream)
+
+	detector := NewStallDetector(3)
+	for beat := range pulses {
+		p := beat.(Pulse)
+		if detector.Observe(p) {
+			assert.Equal(t, int64(2), p.Processed)
+			assert.Equal(t, int64(2), p.Offset)
+			assert.Equal(t, "processing", p.Status)
+			return
+		}
+	}
+	t.Fatal("pulses stopped before a stall was detected")
+}
+
+func TestHeartbeatWithProgressHealthy(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+	ints := []int{0, 1, 2, 3, 5}
+
+	pulses, intStream := HeartbeatWithProgress(done, time.Millisecond, -1, ints...)
+	i := 0
+	for v := range intStream {
+		assert.Equal(t, ints[i], v)
+		i++
+	}
+	assert.Equal(t, len(ints), i)
+	// the producer is done, which closes the heartbeat too
+	for range pulses {
+	}
+}
@@ -1,6 +1,9 @@
 package heartbeats
 
-import "time"
+import (
+	"patterns/clock"
+	"time"
+)
 
 // Zen: Heartbeats are a way for concurrent processes to signal life to outside properties.
 // They can occur on a timed interval (useful for concurrent code waiting for something else
@@ -11,14 +14,23 @@ import "time"
 // HeartbeatAndResult is a function that provides a channel which is signalled every
 // pulse interval seconds along with a result channel that is signalled at double the interval
 func HeartbeatAndResult(done <-chan interface{}, pulseInterval time.Duration) (<-chan interface{}, <-chan time.Time) {
+	return HeartbeatAndResultWithClock(done, clock.New(), pulseInterval)
+}
+
+// HeartbeatAndResultWithClock is same as HeartbeatAndResult but reads time from the given
+// clock, so that tests can drive it with a virtual one instead of waiting on the wall clock
+func HeartbeatAndResultWithClock(done <-chan interface{}, clk clock.Clock, pulseInterval time.Duration) (<-chan interface{}, <-chan time.Time) {
 	heartbeatCh := make(chan interface{})
 	resultCh := make(chan time.Time) // result channel could be on anything, we just send time
 
 	go func() {
 		defer close(heartbeatCh)
 		defer close(resultCh)
-		pulse := time.Tick(pulseInterval)
-		workGen := time.Tick(pulseInterval * 2) // we choose twice the interval arbitrarily
+		pulseTicker := clk.NewTicker(pulseInterval)
+		defer pulseTicker.Stop()
+		workGenTicker := clk.NewTicker(pulseInterval * 2) // we choose twice the interval arbitrarily
+		defer workGenTicker.Stop()
+		pulse, workGen := pulseTicker.C(), workGenTicker.C()
 
 		sendWorkResult := func(t time.Time) {
 			for {
@@ -60,13 +72,22 @@ func sendPulse(heartbeatCh chan<- interface{}) {
 // and doesn't close its channel, which results in a panic. This will be detected as no pulse and
 // the main goroutine can take appropriate action
 func HeartbeatAndResultFaulty(done <-chan interface{}, pulseInterval time.Duration) (<-chan interface{}, <-chan time.Time) {
+	return HeartbeatAndResultFaultyWithClock(done, clock.New(), pulseInterval)
+}
+
+// HeartbeatAndResultFaultyWithClock is same as HeartbeatAndResultFaulty but reads time from
+// the given clock
+func HeartbeatAndResultFaultyWithClock(done <-chan interface{}, clk clock.Clock, pulseInterval time.Duration) (<-chan interface{}, <-chan time.Time) {
 	heartbeatCh := make(chan interface{})
 	resultCh := make(chan time.Time) // result channel could be on anything, we just send time
 
 	go func() {
 		// we forget to close the channel, resulting in a panic
-		pulse := time.Tick(pulseInterval)
-		workGen := time.Tick(pulseInterval * 2) // we choose twice the interval arbitrarily
+		pulseTicker := clk.NewTicker(pulseInterval)
+		defer pulseTicker.Stop()
+		workGenTicker := clk.NewTicker(pulseInterval * 2) // we choose twice the interval arbitrarily
+		defer workGenTicker.Stop()
+		pulse, workGen := pulseTicker.C(), workGenTicker.C()
 
 		sendWorkResult := func(t time.Time) {
 			for {
@@ -0,0 +1,396 @@
+package heartbeattest
+
+import (
+	"fmt"
+	"patterns/clock"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Zen: Tests of heartbeat producers that wait on the wall clock have to choose a timeout.
+// If it is too high, failures take long to show up, if it is too low, the test is flaky.
+// Here, the producer reads time from a virtual clock which only the test moves forward,
+// one pulse interval at a time. After every step, the harness waits for the goroutines of the
+// producer to block again, on their tickers or on a result nobody takes, as told by the state
+// of goroutines the runtime reports. By then, whatever the producer does in response to the
+// step has been done, so pulses and results are counted by step rather than by how long they
+// took to show up. A healthy producer must react to every interval with a pulse, or a result.
+
+// DefaultGrace is how long the harness waits in real time for a producer to block again after
+// a step before declaring that it is stuck busy
+const DefaultGrace = time.Second
+
+// TB is the subset of testing.TB used by the harness
+type TB interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+// Producer starts a heartbeat-emitting producer that reads time only from the given clock
+type Producer[T any] func(done <-chan interface{}, clk clock.Clock) (heartbeat <-chan interface{}, results <-chan T)
+
+// Options configure how a producer is run by the harness
+type Options struct {
+	// Interval is the pulse interval of the producer, by which the clock is advanced every step
+	Interval time.Duration
+	// Grace is the real time allowed for the producer to block again after a step, defaults to
+	// DefaultGrace
+	Grace time.Duration
+}
+
+// Harness runs a heartbeat producer against a virtual clock. Results are only taken from the
+// producer when asked for, so that the test decides when the producer is blocked sending one.
+type Harness[T any] struct {
+	t       TB
+	opts    Options
+	clock   *clock.Virtual
+	done    chan interface{}
+	pulses  chan interface{}
+	results <-chan T
+	steps   int
+	// watched holds the IDs of the goroutines of the producer, see watch
+	watched map[int]bool
+}
+
+// Run starts the producer against a virtual clock. The producer is stopped when the test ends.
+func Run[T any](t TB, opts Options, producer Producer[T]) *Harness[T] {
+	t.Helper()
+	if opts.Interval <= 0 {
+		t.Fatalf("heartbeattest: a positive pulse interval is required, got %v", opts.Interval)
+	}
+	if opts.Grace <= 0 {
+		opts.Grace = DefaultGrace
+	}
+	h := &Harness[T]{
+		t:     t,
+		opts:  opts,
+		clock: clock.NewVirtual(time.Unix(0, 0)),
+		done:  make(chan interface{}),
+		// pulses are buffered so that the producer never has to wait for the test to read them
+		pulses:  make(chan interface{}, 128),
+		watched: make(map[int]bool),
+	}
+	self, started := currentGoroutine(), lastGoroutine()
+	heartbeat, results := producer(h.done, h.clock)
+	h.results = results
+	draining := make(chan struct{})
+	go h.drain(heartbeat, draining)
+	t.Cleanup(func() { close(h.done) })
+	<-draining
+	// the drain is watched along with the producer, so that its pulses have been forwarded once
+	// they are all blocked
+	h.watch(self, started)
+
+	// moving the clock before the producer is waiting on it would skew its ticks
+	h.settle()
+	return h
+}
+
+// drain forwards pulses from the producer, so that those sent without blocking aren't dropped
+func (h *Harness[T]) drain(heartbeat <-chan interface{}, draining chan<- struct{}) {
+	defer close(h.pulses)
+	close(draining)
+	for {
+		select {
+		case <-h.done:
+			return
+		case p, ok := <-heartbeat:
+			if !ok {
+				return
+			}
+			select {
+			case h.pulses <- p:
+			case <-h.done:
+				return
+			}
+		}
+	}
+}
+
+// Clock returns the virtual clock that the producer reads time from
+func (h *Harness[T]) Clock() *clock.Virtual {
+	return h.clock
+}
+
+// Step advances the virtual clock by one pulse interval, and waits for the producer to be done
+// reacting to it
+func (h *Harness[T]) Step() {
+	h.t.Helper()
+	h.clock.Advance(h.opts.Interval)
+	h.steps++
+	h.settle()
+}
+
+// ExpectPulse advances the virtual clock by one pulse interval and returns the pulse which
+// the producer must send in response, without taking any of its results. It fails the test if
+// the producer stops pulsing, which is what happens when a blocked producer forgets its ticker.
+func (h *Harness[T]) ExpectPulse() interface{} {
+	h.t.Helper()
+	h.Step()
+	select {
+	case p, ok := <-h.pulses:
+		if !ok {
+			h.t.Fatalf("heartbeattest: heartbeat closed at step %d, expected a pulse", h.steps)
+		}
+		return p
+	default:
+		h.t.Fatalf("heartbeattest: producer stopped pulsing: no pulse after step %d (virtual time %v)", h.steps, h.elapsed())
+	}
+	return nil
+}
+
+// ExpectPulsesWhileBlocked advances the virtual clock by n pulse intervals while the producer
+// is blocked with nobody taking its results, and expects a pulse for every one of them
+func (h *Harness[T]) ExpectPulsesWhileBlocked(n int) {
+	h.t.Helper()
+	for i := 0; i < n; i++ {
+		h.ExpectPulse()
+	}
+}
+
+// NextResult advances the virtual clock one pulse interval at a time until the producer sends
+// a result, for at most maxSteps intervals. It returns the result along with the number of
+// pulses received since the previous result. It fails the test if the producer neither pulses
+// nor sends a result in response to a step, or if no result arrives within maxSteps.
+func (h *Harness[T]) NextResult(maxSteps int) (T, int) {
+	h.t.Helper()
+	// pulses sent in response to earlier steps count towards this result
+	pulses := h.expectPulses()
+	if r, ok, sent := h.takeResult(); sent {
+		return h.result(r, ok, pulses)
+	}
+	for step := 0; step < maxSteps; step++ {
+		h.Step()
+		n := h.expectPulses()
+		pulses += n
+		// the producer is blocked again, so a result it has for this step is waiting to be taken
+		if r, ok, sent := h.takeResult(); sent {
+			return h.result(r, ok, pulses)
+		}
+		if n == 0 {
+			h.t.Fatalf("heartbeattest: producer stopped pulsing: no pulse or result after step %d (virtual time %v)", h.steps, h.elapsed())
+		}
+	}
+	h.t.Fatalf("heartbeattest: no result within %d steps, got %d pulses", maxSteps, pulses)
+	var zero T
+	return zero, pulses
+}
+
+// ExpectPulsesBetweenResults takes the next result and fails the test unless the number of
+// pulses received since the previous result lies within [min, max]
+func (h *Harness[T]) ExpectPulsesBetweenResults(min, max int) T {
+	h.t.Helper()
+	r, pulses := h.NextResult(max + 1)
+	if pulses < min || pulses > max {
+		h.t.Fatalf("heartbeattest: expected between %d and %d pulses before result %v, got %d", min, max, r, pulses)
+	}
+	return r
+}
+
+// takePulses takes the pulses forwarded so far and returns how many there were, and whether the
+// heartbeat is still open
+func (h *Harness[T]) takePulses() (int, bool) {
+	for n := 0; ; n++ {
+		select {
+		case _, ok := <-h.pulses:
+			if !ok {
+				return n, false
+			}
+		default:
+			return n, true
+		}
+	}
+}
+
+// expectPulses is takePulses for when a result is still expected, which it fails the test for if
+// the heartbeat is closed
+func (h *Harness[T]) expectPulses() int {
+	h.t.Helper()
+	n, open := h.takePulses()
+	if !open {
+		h.t.Fatalf("heartbeattest: heartbeat closed at step %d while waiting for a result", h.steps)
+	}
+	return n
+}
+
+// takeResult takes the result the producer is blocked sending, if any, and reports whether
+// there was one
+func (h *Harness[T]) takeResult() (r T, ok, sent bool) {
+	select {
+	case r, ok = <-h.results:
+		return r, ok, true
+	default:
+		return r, false, false
+	}
+}
+
+// result counts the pulses that the producer sends in response to the current step once its
+// result has been taken, as it may have taken the result before its pulse. The producer may
+// also be done once its last result is taken, and close its heartbeat.
+func (h *Harness[T]) result(r T, ok bool, pulses int) (T, int) {
+	h.t.Helper()
+	if !ok {
+		h.t.Fatalf("heartbeattest: results closed at step %d while waiting for a result", h.steps)
+	}
+	h.settle()
+	n, _ := h.takePulses()
+	return r, pulses + n
+}
+
+// settle waits until every goroutine of the producer is blocked, which is when it is done
+// reacting to the clock. It fails the test if they are still busy once the grace period is over.
+func (h *Harness[T]) settle() {
+	h.t.Helper()
+	deadline := time.Now().Add(h.opts.Grace)
+	for !h.blocked() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("heartbeattest: producer still busy %v after step %d (virtual time %v)", h.opts.Grace, h.steps, h.elapsed())
+		}
+		time.Sleep(50 * time.Microsecond)
+	}
+}
+
+// watch starts watching the goroutines created by the goroutine self after the one with the
+// ID started, which are those of the producer and the drain
+func (h *Harness[T]) watch(self, started int) {
+	for _, g := range goroutines() {
+		if g.id > started && g.parent == self {
+			h.watched[g.id] = true
+		}
+	}
+}
+
+// blocked reports whether every goroutine watched is blocked. The goroutines they started are
+// watched from then on, in the order they were created so that their own are found too.
+func (h *Harness[T]) blocked() bool {
+	blocked := true
+	for _, g := range goroutines() {
+		if !h.watched[g.id] {
+			if !h.watched[g.parent] {
+				continue
+			}
+			h.watched[g.id] = true
+		}
+		blocked = blocked && g.blocked()
+	}
+	return blocked
+}
+
+func (h *Harness[T]) elapsed() time.Duration {
+	return h.clock.Since(time.Unix(0, 0))
+}
+
+// Failure runs fn the way a test would run it, against a TB that records the failure instead of
+// failing the test. It returns the failure message, if any, so that tests can assert that a
+// faulty producer is caught by the harness.
+func Failure(fn func(tb TB)) string {
+	r := &recorder{}
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		fn(r)
+	}()
+	<-finished
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+	return r.failure
+}
+
+type recorder struct {
+	failure  string
+	cleanups []func()
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+func (r *recorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+// goroutine is a goroutine as found in the dump of runtime.Stack
+type goroutine struct {
+	id, parent int
+	// state is the wait reason of a blocked goroutine, such as "select" or "chan send"
+	state string
+}
+
+// blocked reports whether the goroutine waits for another one. One that sleeps waits on the wall
+// clock instead, as if it was working.
+func (g goroutine) blocked() bool {
+	switch g.state {
+	case "running", "runnable", "syscall", "sleep", "preempted", "copystack":
+		return false
+	}
+	return !strings.HasPrefix(g.state, "GC")
+}
+
+// goroutines returns every goroutine, ordered by ID
+func goroutines() []goroutine {
+	var gs []goroutine
+	for _, dump := range strings.Split(stacks(true), "\n\n") {
+		if g, ok := parseGoroutine(dump); ok {
+			gs = append(gs, g)
+		}
+	}
+	sort.Slice(gs, func(i, j int) bool { return gs[i].id < gs[j].id })
+	return gs
+}
+
+// currentGoroutine returns the ID of the calling goroutine
+func currentGoroutine() int {
+	g, _ := parseGoroutine(stacks(false))
+	return g.id
+}
+
+// lastGoroutine returns the ID of the goroutine created last, which is the highest one
+func lastGoroutine() int {
+	gs := goroutines()
+	return gs[len(gs)-1].id
+}
+
+func stacks(all bool) string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, all)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// parseGoroutine parses the dump of a goroutine, which starts with a header such as
+// "goroutine 7 [chan receive, 2 minutes]:" and ends with "created by f in goroutine 6"
+func parseGoroutine(dump string) (goroutine, bool) {
+	header, _, _ := strings.Cut(dump, "\n")
+	rest, ok := strings.CutPrefix(header, "goroutine ")
+	if !ok {
+		return goroutine{}, false
+	}
+	id, state, ok := strings.Cut(rest, " [")
+	if !ok {
+		return goroutine{}, false
+	}
+	g := goroutine{}
+	var err error
+	if g.id, err = strconv.Atoi(id); err != nil {
+		return goroutine{}, false
+	}
+	state, _, _ = strings.Cut(strings.TrimSuffix(state, "]:"), ",")
+	g.state = state
+	if i := strings.LastIndex(dump, " in goroutine "); i >= 0 {
+		parent, _, _ := strings.Cut(dump[i+len(" in goroutine "):], "\n")
+		g.parent, _ = strconv.Atoi(parent)
+	}
+	return g, true
+}
@@ -0,0 +1,93 @@
+package heartbeattest
+
+import (
+	"patterns/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+const interval = time.Second
+
+// producer sends a result every other interval and pulses on every interval. If pulseWhileBlocked
+// is false, it forgets to pulse while it is blocked sending the result.
+func producer(pulseWhileBlocked bool) Producer[int] {
+	return func(done <-chan interface{}, clk clock.Clock) (<-chan interface{}, <-chan int) {
+		heartbeat := make(chan interface{})
+		results := make(chan int)
+		go func() {
+			defer close(heartbeat)
+			defer close(results)
+			pulse := clk.NewTicker(interval)
+			defer pulse.Stop()
+			pulseCh := pulse.C()
+			if !pulseWhileBlocked {
+				pulseCh = nil
+			}
+			for n := 0; ; n++ {
+				select {
+				case <-done:
+					return
+				case <-pulse.C():
+					select {
+					case heartbeat <- struct{}{}:
+					default:
+					}
+				}
+				if n%2 == 0 {
+					continue
+				}
+				for sent := false; !sent; {
+					select {
+					case <-done:
+						return
+					case <-pulseCh:
+						select {
+						case heartbeat <- struct{}{}:
+						default:
+						}
+					case results <- n:
+						sent = true
+					}
+				}
+			}
+		}()
+		return heartbeat, results
+	}
+}
+
+func TestHarnessHealthyProducer(t *testing.T) {
+	h := Run(t, Options{Interval: interval}, producer(true))
+
+	assert.Equal(t, 1, h.ExpectPulsesBetweenResults(2, 2))
+	assert.Equal(t, 3, h.ExpectPulsesBetweenResults(2, 2))
+	// nobody takes the next result, yet the producer must keep pulsing
+	h.ExpectPulsesWhileBlocked(2)
+	h.ExpectPulsesWhileBlocked(3)
+	r, pulses := h.NextResult(1)
+	assert.Equal(t, 5, r)
+	assert.Equal(t, 0, pulses)
+	assert.Equal(t, 9*interval, h.Clock().Since(time.Unix(0, 0)))
+}
+
+func TestHarnessDetectsProducerNotPulsingWhileBlocked(t *testing.T) {
+	failure := Failure(func(tb TB) {
+		h := Run(tb, Options{Interval: interval}, producer(false))
+		h.ExpectPulsesWhileBlocked(4)
+	})
+	assert.Equal(t, "heartbeattest: producer stopped pulsing: no pulse after step 3 (virtual time 3s)", failure)
+}
+
+func TestHarnessDetectsTooManyPulses(t *testing.T) {
+	failure := Failure(func(tb TB) {
+		h := Run(tb, Options{Interval: interval}, producer(true))
+		h.ExpectPulsesBetweenResults(0, 1)
+	})
+	assert.Equal(t, "heartbeattest: expected between 0 and 1 pulses before result 1, got 2", failure)
+}
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
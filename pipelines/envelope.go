@@ -0,0 +1,224 @@
+package pipelines
+
+import (
+	"context"
+	"fmt"
+	"patterns/clock"
+	"patterns/contexts"
+	eh "patterns/error_handling"
+	"strings"
+	"time"
+)
+
+// Zen: Once an item leaves the request that produced it for a channel, nothing links the two
+// anymore: a stage can't tell which request it works for, nor whether anyone still waits for
+// the result. An envelope carries what the item needs of its request's context along with it:
+// a trace ID to log with, the deadline after which the work is wasted, and the baggage. Items
+// already travel as error_handling.Result, whose Meta is the envelope. The context itself isn't
+// carried, as it would keep the request alive, and its cancellation would reach into stages
+// shared by other requests. Every stage rebuilds a context from the envelope to work under, and
+// drops the items that expired on the way. Errors are never dropped, expired or not.
+
+// TraceIDKey holds the ID tying work to the request it is done for
+var TraceIDKey = contexts.NewKey[string]("trace id").Listed()
+
+// BaggageKey holds request scoped key values, such as a tenant or a user
+var BaggageKey = contexts.NewKey[map[string]string]("baggage").Listed()
+
+// The keys of the envelope in the Meta of a result
+const (
+	TraceIDMeta = "trace_id"
+	// DeadlineMeta holds the deadline in RFC 3339 format, and is absent when there is none
+	DeadlineMeta = "deadline"
+	// BaggageMetaPrefix prefixes every key of the baggage
+	BaggageMetaPrefix = "baggage."
+)
+
+// Seal puts v into a result whose envelope is that of ctx
+func Seal[T any](ctx context.Context, v T) eh.Result[T] {
+	return eh.Result[T]{Value: v, Meta: SealMeta(ctx)}
+}
+
+// SealMeta returns the envelope of ctx: its trace ID, deadline and baggage
+func SealMeta(ctx context.Context) eh.Meta {
+	meta := eh.Meta{TraceIDMeta: TraceIDKey.Value(ctx)}
+	if deadline, ok := ctx.Deadline(); ok {
+		meta[DeadlineMeta] = deadline.Format(time.RFC3339Nano)
+	}
+	for k, v := range BaggageKey.Value(ctx) {
+		meta[BaggageMetaPrefix+k] = v
+	}
+	return meta
+}
+
+// Deadline returns the deadline of the envelope, if it has one
+func Deadline(meta eh.Meta) (time.Time, bool) {
+	deadline, err := time.Parse(time.RFC3339Nano, meta[DeadlineMeta])
+	return deadline, err == nil
+}
+
+// Baggage returns the baggage of the envelope
+func Baggage(meta eh.Meta) map[string]string {
+	var baggage map[string]string
+	for k, v := range meta {
+		if strings.HasPrefix(k, BaggageMetaPrefix) {
+			if baggage == nil {
+				baggage = make(map[string]string)
+			}
+			baggage[strings.TrimPrefix(k, BaggageMetaPrefix)] = v
+		}
+	}
+	return baggage
+}
+
+// EnvelopeOptions configure the stages of an envelope pipeline. The zero value is usable.
+type EnvelopeOptions struct {
+	// OnDrop is called with the envelope of every item dropped for having expired
+	OnDrop func(eh.Meta)
+	// Clock tells whether items expired, and how much time stages have left to work on them
+	Clock clock.Clock
+}
+
+func (opts EnvelopeOptions) clock() clock.Clock {
+	if opts.Clock == nil {
+		return clock.New()
+	}
+	return opts.Clock
+}
+
+// Context returns a context derived from parent holding the trace ID and baggage of the
+// envelope, which expires once the time left until its deadline on the clock of opts has passed
+func (opts EnvelopeOptions) Context(parent context.Context, meta eh.Meta) (context.Context, context.CancelFunc) {
+	ctx := TraceIDKey.WithValue(parent, meta[TraceIDMeta])
+	if baggage := Baggage(meta); baggage != nil {
+		ctx = BaggageKey.WithValue(ctx, baggage)
+	}
+	deadline, ok := Deadline(meta)
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, deadline.Sub(opts.clock().Now()))
+}
+
+// drop reports whether a result is to be dropped, for its deadline has passed, and calls OnDrop
+// if so. Errors are never dropped, as they pass through untouched.
+func drop[T any](opts EnvelopeOptions, r eh.Result[T]) bool {
+	deadline, ok := Deadline(r.Meta)
+	if r.Err != nil || !ok || opts.clock().Now().Before(deadline) {
+		return false
+	}
+	if opts.OnDrop != nil {
+		opts.OnDrop(r.Meta)
+	}
+	return true
+}
+
+// doneContext returns a context cancelled once done is closed, so that done reaches the
+// functions of stages. It must be cancelled once the stage returns.
+func doneContext(done <-chan interface{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// EnvelopeGenerator seals every input with the envelope of ctx, each in a Meta of its own
+func EnvelopeGenerator[T any](ctx context.Context, done <-chan interface{}, input ...T) <-chan eh.Result[T] {
+	ch := make(chan eh.Result[T])
+	go func() {
+		defer close(ch)
+		for _, v := range input {
+			select {
+			case <-done:
+				return
+			case ch <- Seal(ctx, v):
+			}
+		}
+	}()
+	return ch
+}
+
+// EnvelopeStage is error_handling.Map, with fn working under a context rebuilt from the
+// envelope of every item, which is also cancelled when done is closed. Expired items are
+// dropped, unless they carry an error.
+func EnvelopeStage[T, U any](done <-chan interface{}, opts EnvelopeOptions, input <-chan eh.Result[T], fn func(ctx context.Context, v T) (U, error)) <-chan eh.Result[U] {
+	res := make(chan eh.Result[U])
+	go func() {
+		defer close(res)
+		base, cancelBase := doneContext(done)
+		defer cancelBase()
+		for r := range input {
+			if drop(opts, r) {
+				continue
+			}
+			out := eh.Result[U]{Err: r.Err, Meta: r.Meta}
+			if r.Err == nil {
+				ctx, cancel := opts.Context(base, r.Meta)
+				out.Value, out.Err = fn(ctx, r.Value)
+				cancel()
+			}
+			select {
+			case <-done:
+				return
+			case res <- out:
+			}
+		}
+	}()
+	return res
+}
+
+// EnvelopeFanOut runs n copies of the stage on the same input. Every item goes through one of
+// them, in its envelope.
+func EnvelopeFanOut[T, U any](done <-chan interface{}, opts EnvelopeOptions, input <-chan eh.Result[T], n int, fn func(ctx context.Context, v T) (U, error)) []<-chan eh.Result[U] {
+	outputs := make([]<-chan eh.Result[U], n)
+	for i := range outputs {
+		outputs[i] = EnvelopeStage(done, opts, input, fn)
+	}
+	return outputs
+}
+
+// EnvelopeFanIn is error_handling.Merge, dropping the items that expired while waiting to be
+// merged, unless they carry an error
+func EnvelopeFanIn[T any](done <-chan interface{}, opts EnvelopeOptions, channels ...<-chan eh.Result[T]) <-chan eh.Result[T] {
+	merged := eh.Merge(done, channels...)
+	res := make(chan eh.Result[T])
+	go func() {
+		defer close(res)
+		for r := range merged {
+			if drop(opts, r) {
+				continue
+			}
+			select {
+			case <-done:
+				return
+			case res <- r:
+			}
+		}
+	}()
+	return res
+}
+
+// TracedChannelStreamPipeline is ChannelStreamPipeline with every item carrying the envelope
+// of ctx, the request it is computed for, through the stages. The add stage is fanned out,
+// and fanned back in, which loses the order of items but not their envelope.
+func TracedChannelStreamPipeline(ctx context.Context, done chan interface{}, opts EnvelopeOptions) <-chan eh.Result[int] {
+	add := func(additive int) func(context.Context, int) (int, error) {
+		return func(ctx context.Context, v int) (int, error) {
+			// stages now know which request they work for
+			fmt.Println("Adding for trace", TraceIDKey.Value(ctx))
+			return v + additive, nil
+		}
+	}
+	multiply := func(multiplier int) func(context.Context, int) (int, error) {
+		return func(_ context.Context, v int) (int, error) { return v * multiplier, nil }
+	}
+	intStream := EnvelopeGenerator(ctx, done, 1, 2, 3, 4)
+	doubled := EnvelopeStage(done, opts, intStream, multiply(2))
+	added := EnvelopeFanIn(done, opts, EnvelopeFanOut(done, opts, doubled, 2, add(1))...)
+	return EnvelopeStage(done, opts, added, multiply(2))
+}
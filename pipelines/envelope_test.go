@@ -0,0 +1,158 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"patterns/clock"
+	eh "patterns/error_handling"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tracedContext(traceID string) context.Context {
+	ctx := TraceIDKey.WithValue(context.Background(), traceID)
+	return BaggageKey.WithValue(ctx, map[string]string{"tenant": "acme"})
+}
+
+func withDeadline(meta eh.Meta, deadline time.Time) eh.Meta {
+	meta[DeadlineMeta] = deadline.Format(time.RFC3339Nano)
+	return meta
+}
+
+func TestSealAndReopen(t *testing.T) {
+	deadline := time.Now().Add(time.Hour)
+	ctx, cancel := context.WithDeadline(tracedContext("req-1"), deadline)
+	defer cancel()
+
+	r := Seal(ctx, 42)
+	assert.Equal(t, 42, r.Value)
+	assert.Equal(t, "req-1", r.Meta[TraceIDMeta])
+	assert.Equal(t, map[string]string{"tenant": "acme"}, Baggage(r.Meta))
+	got, ok := Deadline(r.Meta)
+	assert.True(t, ok)
+	assert.True(t, deadline.Equal(got))
+
+	reopened, cancelReopened := EnvelopeOptions{}.Context(context.Background(), r.Meta)
+	defer cancelReopened()
+	assert.Equal(t, "req-1", TraceIDKey.Value(reopened))
+	assert.Equal(t, "acme", BaggageKey.Value(reopened)["tenant"])
+	got, ok = reopened.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, deadline, got, time.Second)
+
+	_, ok = Deadline(SealMeta(context.Background()))
+	assert.False(t, ok)
+}
+
+func TestContextFollowsTheClockOfOptions(t *testing.T) {
+	// on the virtual clock, a minute is left until the deadline, whatever the wall clock says
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	meta := withDeadline(eh.Meta{}, clk.Now().Add(time.Minute))
+
+	ctx, cancel := EnvelopeOptions{Clock: clk}.Context(context.Background(), meta)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+	assert.NoError(t, ctx.Err())
+}
+
+func TestTracedChannelStreamPipeline(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	var outputs []int
+	for r := range TracedChannelStreamPipeline(tracedContext("req-2"), done, EnvelopeOptions{}) {
+		assert.NoError(t, r.Err)
+		assert.Equal(t, "req-2", r.Meta[TraceIDMeta])
+		assert.Equal(t, "acme", Baggage(r.Meta)["tenant"])
+		outputs = append(outputs, r.Value)
+	}
+	// fanning in loses the order
+	sort.Ints(outputs)
+	assert.Equal(t, []int{6, 10, 14, 18}, outputs)
+}
+
+func TestEnvelopeStageDropsExpiredItems(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	var dropped []string
+	opts := EnvelopeOptions{Clock: clk, OnDrop: func(meta eh.Meta) { dropped = append(dropped, meta[TraceIDMeta]) }}
+
+	errUpstream := errors.New("upstream")
+	input := make(chan eh.Result[int], 4)
+	input <- eh.Result[int]{Value: 1, Meta: withDeadline(eh.Meta{TraceIDMeta: "expired"}, clk.Now())}
+	input <- eh.Result[int]{Value: 2, Meta: eh.Meta{TraceIDMeta: "no deadline"}}
+	input <- eh.Result[int]{Value: 3, Meta: withDeadline(eh.Meta{TraceIDMeta: "in time"}, clk.Now().Add(time.Second))}
+	// errors pass through even once expired
+	input <- eh.Result[int]{Err: errUpstream, Meta: withDeadline(eh.Meta{TraceIDMeta: "failed"}, clk.Now())}
+	close(input)
+
+	var results []eh.Result[string]
+	for r := range EnvelopeStage(done, opts, input, func(ctx context.Context, v int) (string, error) {
+		return TraceIDKey.Value(ctx), nil
+	}) {
+		results = append(results, r)
+	}
+	assert.Len(t, results, 3)
+	assert.Equal(t, "no deadline", results[0].Value)
+	assert.Equal(t, "in time", results[1].Value)
+	// errors pass through untouched, along with their envelope
+	assert.Equal(t, errUpstream, results[2].Err)
+	assert.Equal(t, "failed", results[2].Meta[TraceIDMeta])
+	assert.Equal(t, []string{"expired"}, dropped)
+}
+
+func TestEnvelopeFanInDropsItemsExpiredWhileWaiting(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	opts := EnvelopeOptions{Clock: clk}
+
+	first, second := make(chan eh.Result[int], 1), make(chan eh.Result[int], 1)
+	first <- eh.Result[int]{Value: 1, Meta: withDeadline(eh.Meta{}, clk.Now().Add(time.Second))}
+	second <- eh.Result[int]{Value: 2, Meta: withDeadline(eh.Meta{}, clk.Now().Add(time.Minute))}
+	close(first)
+	close(second)
+	clk.Advance(time.Second)
+
+	var values []int
+	for r := range EnvelopeFanIn(done, opts, first, second) {
+		values = append(values, r.Value)
+	}
+	assert.Equal(t, []int{2}, values)
+}
+
+func TestEnvelopeStageDoneReachesFn(t *testing.T) {
+	done := make(chan interface{})
+	input := make(chan eh.Result[int], 1)
+	input <- eh.Result[int]{Value: 1, Meta: eh.Meta{}}
+
+	// an item without a deadline is only stopped by done
+	started := make(chan struct{})
+	out := EnvelopeStage(done, EnvelopeOptions{}, input, func(ctx context.Context, v int) (int, error) {
+		close(started)
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	<-started
+	close(done)
+	close(input)
+	for range out {
+	}
+}
+
+func TestEnvelopeGeneratorGivesEveryItemItsOwnMeta(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	results := EnvelopeGenerator(tracedContext("req-3"), done, 1, 2)
+	first, second := <-results, <-results
+	first.Meta["stage"] = "written"
+	assert.NotContains(t, second.Meta, "stage")
+	assert.Equal(t, "req-3", second.Meta[TraceIDMeta])
+}
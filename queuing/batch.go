@@ -0,0 +1,80 @@
+package queuing
+
+import (
+	"context"
+	"patterns/clock"
+	"time"
+)
+
+// Zen: Batching pays off when handling n items at once costs less than n times handling one,
+// as with the buffered writes above (A1). A batch that waits to fill up adds lag, though, and
+// waits forever on a quiet stream. So a batch is sent when it is full or when its first item has
+// lingered long enough, whichever comes first: busy streams get full batches, quiet ones bounded lag.
+
+// Batch groups the items of in into slices of up to maxSize items, sending each as soon as it is
+// full or maxWait after its first item arrived. What's left is sent when in is closed. Cancelling
+// ctx stops the stage and discards the batch in progress.
+func Batch[T any](ctx context.Context, in <-chan T, maxSize int, maxWait time.Duration) <-chan []T {
+	return BatchWithClock(ctx, clock.New(), in, maxSize, maxWait)
+}
+
+// BatchWithClock is Batch with its linger timer on the given clock
+func BatchWithClock[T any](ctx context.Context, clk clock.Clock, in <-chan T, maxSize int, maxWait time.Duration) <-chan []T {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		// a single timer, armed by the first item of every batch and disarmed when it is sent
+		linger := clk.NewTimer(maxWait)
+		defer linger.Stop()
+		disarm := func() {
+			if !linger.Stop() {
+				select {
+				case <-linger.C():
+				default:
+				}
+			}
+		}
+		disarm()
+
+		var batch []T
+		flush := func() bool {
+			disarm()
+			select {
+			case <-ctx.Done():
+				return false
+			case out <- batch:
+				batch = nil
+				return true
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-linger.C():
+				// the timer only runs while a batch is in progress
+				if !flush() {
+					return
+				}
+			case v, ok := <-in:
+				if !ok {
+					if len(batch) > 0 {
+						flush()
+					}
+					return
+				}
+				if len(batch) == 0 {
+					linger.Reset(maxWait)
+				}
+				batch = append(batch, v)
+				if len(batch) >= maxSize && !flush() {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
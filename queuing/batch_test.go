@@ -0,0 +1,63 @@
+package queuing
+
+import (
+	"context"
+	"patterns/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchBySize(t *testing.T) {
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 7; i++ {
+			in <- i
+		}
+	}()
+	var batches [][]int
+	for b := range Batch(context.Background(), in, 3, time.Hour) {
+		batches = append(batches, b)
+	}
+	// what's left is flushed once in is closed
+	assert.Equal(t, [][]int{{0, 1, 2}, {3, 4, 5}, {6}}, batches)
+}
+
+func TestBatchByTime(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	in := make(chan int)
+	out := BatchWithClock(context.Background(), clk, in, 10, time.Second)
+
+	in <- 1
+	in <- 2
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	assert.Equal(t, []int{1, 2}, <-out)
+
+	// the timer is only armed by the first item of the next batch
+	clk.Advance(10 * time.Second)
+	in <- 3
+	clk.BlockUntil(1)
+	clk.Advance(500 * time.Millisecond)
+	in <- 4
+	clk.Advance(500 * time.Millisecond)
+	assert.Equal(t, []int{3, 4}, <-out)
+
+	close(in)
+	_, ok := <-out
+	assert.False(t, ok)
+}
+
+func TestBatchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	out := Batch(ctx, in, 10, time.Hour)
+	in <- 1
+	cancel()
+	// the batch in progress is discarded, and the stage stops though in is never closed
+	for range out {
+		t.Fatal("no batch expected")
+	}
+}
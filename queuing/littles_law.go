@@ -0,0 +1,153 @@
+package queuing
+
+import (
+	"context"
+	"math"
+	"patterns/clock"
+	"sync"
+	"time"
+)
+
+// Zen: Little's Law ties the three numbers of any stable system together: the items in it (L)
+// are the rate at which they arrive (λ) times the time each spends in it (W). Measure how fast
+// items arrive and how long they stay, and the buffer a stage needs to absorb them follows,
+// rather than being a guess. Whether the queue trades utilisation for lag shows in the numbers
+// too: the service rate (μ) is how many items the stage could handle per second if kept busy,
+// and utilisation is λ/μ. The closer it gets to 1, the less idle the stage, and the more W grows
+// as items wait their turn. The departure rate can't tell this, as it follows arrivals anyway.
+
+// Meter records items arriving to and departing from a queue or a stage. It is safe for
+// concurrent use.
+type Meter struct {
+	clk   clock.Clock
+	start time.Time
+
+	mu         sync.Mutex
+	arrivals   int64
+	departures int64
+	totalTime  time.Duration
+	served     int64
+	busyTime   time.Duration
+}
+
+// Rates are what a meter has measured since it was created
+type Rates struct {
+	// Elapsed is the time the meter has been measuring for
+	Elapsed time.Duration
+	// Arrivals and Departures are the number of items that came in and left
+	Arrivals   int64
+	Departures int64
+	// ArrivalRate is the number of items arriving per second, λ
+	ArrivalRate float64
+	// DepartureRate is the number of items leaving per second, the throughput
+	DepartureRate float64
+	// TimeInSystem is the mean time items that left spent in the queue or stage, W
+	TimeInSystem time.Duration
+	// ServiceTime is the mean time spent working on an item, not counting its wait
+	ServiceTime time.Duration
+	// ServiceRate is the number of items that can be worked on per second, μ, the inverse of
+	// ServiceTime. It is only known when service times are recorded with Serve.
+	ServiceRate float64
+	// Utilisation is the share of time spent working, λ/μ, above 1 when the stage can't keep up
+	Utilisation float64
+}
+
+// NewMeter returns a meter that measures from now on. The clock defaults to the wall clock.
+func NewMeter(clk clock.Clock) *Meter {
+	if clk == nil {
+		clk = clock.New()
+	}
+	return &Meter{clk: clk, start: clk.Now()}
+}
+
+// Arrive records an item arriving and returns its time of arrival, to hand back to Depart
+func (m *Meter) Arrive() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.arrivals++
+	return m.clk.Now()
+}
+
+// Depart records an item that arrived at the given time leaving
+func (m *Meter) Depart(arrived time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.departures++
+	m.totalTime += m.clk.Since(arrived)
+}
+
+// Serve records the time spent working on an item, such as the time a stage took to process it
+func (m *Meter) Serve(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.served++
+	m.busyTime += d
+}
+
+// Rates returns what the meter has measured so far
+func (m *Meter) Rates() Rates {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := Rates{Elapsed: m.clk.Since(m.start), Arrivals: m.arrivals, Departures: m.departures}
+	if seconds := r.Elapsed.Seconds(); seconds > 0 {
+		r.ArrivalRate = float64(r.Arrivals) / seconds
+		r.DepartureRate = float64(r.Departures) / seconds
+	}
+	if r.Departures > 0 {
+		r.TimeInSystem = m.totalTime / time.Duration(r.Departures)
+	}
+	if m.served > 0 {
+		r.ServiceTime = m.busyTime / time.Duration(m.served)
+	}
+	if r.ServiceTime > 0 {
+		r.ServiceRate = 1 / r.ServiceTime.Seconds()
+		r.Utilisation = r.ArrivalRate / r.ServiceRate
+	}
+	return r
+}
+
+// RecommendBuffer returns the buffer size that holds the items in the system on average, as per
+// Little's Law, from the measured rates
+func (r Rates) RecommendBuffer() int {
+	return RecommendBuffer(r.ArrivalRate, r.TimeInSystem)
+}
+
+// RecommendBuffer returns L = λW rounded up: the number of items in the system on average when
+// arrivalRate items arrive per second and each spends timeInSystem in it
+func RecommendBuffer(arrivalRate float64, timeInSystem time.Duration) int {
+	if arrivalRate <= 0 || timeInSystem <= 0 {
+		return 0
+	}
+	return int(math.Ceil(arrivalRate * timeInSystem.Seconds()))
+}
+
+// Measure wraps a pipeline stage, recording every item read from in as an arrival and every
+// item fn returns for it as a departure once it has been sent on. The time in system of the
+// stage is then the time fn took plus the time the stage below took to take the result, while
+// the time fn took alone is recorded as the service time.
+func Measure[T, U any](ctx context.Context, m *Meter, in <-chan T, fn func(T) U) <-chan U {
+	out := make(chan U)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				arrived := m.Arrive()
+				u := fn(v)
+				m.Serve(m.clk.Since(arrived))
+				select {
+				case <-ctx.Done():
+					return
+				case out <- u:
+					m.Depart(arrived)
+				}
+			}
+		}
+	}()
+	return out
+}
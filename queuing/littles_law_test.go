@@ -0,0 +1,108 @@
+package queuing
+
+import (
+	"context"
+	"patterns/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecommendBuffer(t *testing.T) {
+	// 100 items a second each staying 50ms make 5 items in the system on average
+	assert.Equal(t, 5, RecommendBuffer(100, 50*time.Millisecond))
+	// rounded up, as a buffer short of one item still blocks
+	assert.Equal(t, 6, RecommendBuffer(110, 50*time.Millisecond))
+	assert.Equal(t, 0, RecommendBuffer(0, time.Second))
+}
+
+func TestMeter(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	m := NewMeter(clk)
+	assert.Equal(t, Rates{}, m.Rates())
+
+	// 4 items arrive over 2 seconds, the first 2 leave after 1 and 3 seconds
+	first := m.Arrive()
+	second := m.Arrive()
+	clk.Advance(time.Second)
+	m.Depart(first)
+	m.Arrive()
+	m.Arrive()
+	clk.Advance(time.Second)
+	clk.Advance(time.Second)
+	m.Depart(second)
+	clk.Advance(time.Second)
+
+	r := m.Rates()
+	assert.Equal(t, Rates{
+		Elapsed: 4 * time.Second, Arrivals: 4, Departures: 2,
+		ArrivalRate: 1, DepartureRate: 0.5, TimeInSystem: 2 * time.Second,
+	}, r)
+	assert.Equal(t, 2, r.RecommendBuffer())
+}
+
+func TestMeterUtilisation(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	m := NewMeter(clk)
+
+	// an item arrives every 100ms and takes 50ms to work on: the stage is busy half the time
+	for i := 0; i < 10; i++ {
+		arrived := m.Arrive()
+		clk.Advance(50 * time.Millisecond)
+		m.Serve(clk.Since(arrived))
+		m.Depart(arrived)
+		clk.Advance(50 * time.Millisecond)
+	}
+	r := m.Rates()
+	assert.Equal(t, 10.0, r.ArrivalRate)
+	assert.Equal(t, 10.0, r.DepartureRate)
+	assert.Equal(t, 50*time.Millisecond, r.ServiceTime)
+	assert.Equal(t, 20.0, r.ServiceRate)
+	assert.Equal(t, 0.5, r.Utilisation)
+}
+
+func TestQueueRates(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	q := NewQueue[int](QueueOptions{Capacity: 10, Clock: clk})
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		_ = q.Push(ctx, i)
+		clk.Advance(100 * time.Millisecond)
+		if i >= 2 {
+			// items leave 300ms after they arrived
+			_, _ = q.Pop(ctx)
+		}
+	}
+	r := q.Rates()
+	assert.Equal(t, 10.0, r.ArrivalRate)
+	assert.Equal(t, 300*time.Millisecond, r.TimeInSystem)
+	assert.Equal(t, 3, r.RecommendBuffer())
+}
+
+func TestMeasure(t *testing.T) {
+	ctx := context.Background()
+	m := NewMeter(nil)
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 5; i++ {
+			in <- i
+		}
+	}()
+	var out []int
+	for v := range Measure(ctx, m, in, func(v int) int {
+		time.Sleep(time.Millisecond)
+		return v * v
+	}) {
+		out = append(out, v)
+	}
+	assert.Equal(t, []int{0, 1, 4, 9, 16}, out)
+	r := m.Rates()
+	assert.Equal(t, int64(5), r.Arrivals)
+	assert.Equal(t, int64(5), r.Departures)
+	assert.GreaterOrEqual(t, r.TimeInSystem, time.Millisecond)
+	assert.GreaterOrEqual(t, r.ServiceTime, time.Millisecond)
+	assert.LessOrEqual(t, r.ServiceTime, r.TimeInSystem)
+}
@@ -0,0 +1,154 @@
+package queuing
+
+import (
+	"context"
+	"patterns/clock"
+	"time"
+)
+
+// Zen: A FIFO queue treats every item alike, so an urgent item waits behind every routine one
+// that came before it. A priority queue serves the most urgent first, but under steady load the
+// least urgent may then never be served at all. Aging fixes that: the longer an item waits, the
+// more urgent it becomes, until it is served like any other. Urgent items still jump the queue,
+// but no item waits forever.
+
+// Prioritized is an item along with its priority, 0 being the highest
+type Prioritized[T any] struct {
+	Value    T
+	Priority int
+}
+
+// PriorityOptions configure a priority queue stage
+type PriorityOptions struct {
+	// Levels is the number of priorities, from 0 to Levels-1. Priorities outside of them are
+	// clamped to the nearest one.
+	Levels int
+	// Capacity is the number of items held, beyond which the stage stops reading its input
+	Capacity int
+	// Aging promotes an item by one level every time it has waited that long, zero disables it
+	Aging time.Duration
+	// Clock is the source of time for the aging, defaults to the wall clock
+	Clock clock.Clock
+}
+
+type aged[T any] struct {
+	value    T
+	level    int
+	enqueued time.Time
+	seq      uint64
+}
+
+// priorityQueue holds a FIFO queue per level. The item served next is the one with the highest
+// priority once aged, the oldest first between equals. Since the head of a level is its oldest
+// item, and hence the most aged one, only the heads need to be compared.
+type priorityQueue[T any] struct {
+	levels [][]aged[T]
+	aging  time.Duration
+	clk    clock.Clock
+	size   int
+	seq    uint64
+}
+
+func newPriorityQueue[T any](opts PriorityOptions) *priorityQueue[T] {
+	if opts.Levels < 1 {
+		opts.Levels = 1
+	}
+	clk := opts.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
+	return &priorityQueue[T]{levels: make([][]aged[T], opts.Levels), aging: opts.Aging, clk: clk}
+}
+
+func (pq *priorityQueue[T]) push(item Prioritized[T]) {
+	level := item.Priority
+	if level < 0 {
+		level = 0
+	}
+	if level >= len(pq.levels) {
+		level = len(pq.levels) - 1
+	}
+	pq.seq++
+	pq.levels[level] = append(pq.levels[level], aged[T]{value: item.Value, level: level, enqueued: pq.clk.Now(), seq: pq.seq})
+	pq.size++
+}
+
+// next returns the level whose head is served next, the caller knowing the queue isn't empty
+func (pq *priorityQueue[T]) next() int {
+	now := pq.clk.Now()
+	best, bestPriority := -1, 0
+	for level, items := range pq.levels {
+		if len(items) == 0 {
+			continue
+		}
+		priority := pq.effective(items[0], now)
+		if best < 0 || priority < bestPriority || (priority == bestPriority && items[0].seq < pq.levels[best][0].seq) {
+			best, bestPriority = level, priority
+		}
+	}
+	return best
+}
+
+// effective returns the priority of an item once aged
+func (pq *priorityQueue[T]) effective(item aged[T], now time.Time) int {
+	if pq.aging <= 0 {
+		return item.level
+	}
+	promoted := item.level - int(now.Sub(item.enqueued)/pq.aging)
+	if promoted < 0 {
+		return 0
+	}
+	return promoted
+}
+
+// pop removes the head of the given level, which may have been chosen by next a while ago, as
+// aging may have changed the choice since
+func (pq *priorityQueue[T]) pop(level int) T {
+	item := pq.levels[level][0]
+	pq.levels[level][0] = aged[T]{}
+	pq.levels[level] = pq.levels[level][1:]
+	pq.size--
+	return item.value
+}
+
+// PriorityStage emits the items of in by priority, the highest available first, promoting
+// items as they age. It holds up to Capacity items, and stops reading in while it's full,
+// pushing back on the stage above. Once in is closed, the items left are emitted before the
+// returned channel is closed. Cancelling ctx stops the stage, discarding the items left.
+func PriorityStage[T any](ctx context.Context, in <-chan Prioritized[T], opts PriorityOptions) <-chan T {
+	if opts.Capacity < 1 {
+		opts.Capacity = 1
+	}
+	pq := newPriorityQueue[T](opts)
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for in != nil || pq.size > 0 {
+			// a nil channel is never selected, which disables reading when full and sending
+			// when empty
+			input, output := in, chan T(nil)
+			if pq.size >= opts.Capacity {
+				input = nil
+			}
+			var next T
+			level := -1
+			if pq.size > 0 {
+				level = pq.next()
+				output, next = out, pq.levels[level][0].value
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-input:
+				if !ok {
+					in = nil
+					continue
+				}
+				pq.push(v)
+			case output <- next:
+				pq.pop(level)
+			}
+		}
+	}()
+	return out
+}
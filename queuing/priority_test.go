@@ -0,0 +1,79 @@
+package queuing
+
+import (
+	"context"
+	"patterns/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityQueueServesHighestFirst(t *testing.T) {
+	pq := newPriorityQueue[string](PriorityOptions{Levels: 3, Clock: clock.NewVirtual(time.Unix(0, 0))})
+	pq.push(Prioritized[string]{Value: "low", Priority: 2})
+	pq.push(Prioritized[string]{Value: "high", Priority: 0})
+	pq.push(Prioritized[string]{Value: "mid", Priority: 1})
+	pq.push(Prioritized[string]{Value: "high again", Priority: 0})
+	// out of range priorities are clamped
+	pq.push(Prioritized[string]{Value: "urgent", Priority: -5})
+	pq.push(Prioritized[string]{Value: "whenever", Priority: 9})
+
+	var out []string
+	for pq.size > 0 {
+		out = append(out, pq.pop(pq.next()))
+	}
+	assert.Equal(t, []string{"high", "high again", "urgent", "mid", "low", "whenever"}, out)
+}
+
+func TestPriorityQueueAging(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	pq := newPriorityQueue[string](PriorityOptions{Levels: 3, Aging: time.Second, Clock: clk})
+	pq.push(Prioritized[string]{Value: "low", Priority: 2})
+	clk.Advance(time.Second)
+	pq.push(Prioritized[string]{Value: "mid", Priority: 1})
+
+	// low has aged into mid, and came first
+	assert.Equal(t, "low", pq.pop(pq.next()))
+
+	pq.push(Prioritized[string]{Value: "low", Priority: 2})
+	pq.push(Prioritized[string]{Value: "high", Priority: 0})
+	clk.Advance(time.Second)
+	// mid has aged into high, and goes first for having been there longer
+	assert.Equal(t, "mid", pq.pop(pq.next()))
+	assert.Equal(t, "high", pq.pop(pq.next()))
+	assert.Equal(t, "low", pq.pop(pq.next()))
+}
+
+func TestPriorityStage(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan Prioritized[int])
+	out := PriorityStage(ctx, in, PriorityOptions{Levels: 2, Capacity: 4})
+
+	// nobody reads out yet, so the stage fills up
+	for i := 0; i < 4; i++ {
+		in <- Prioritized[int]{Value: i, Priority: 1 - i%2}
+	}
+	select {
+	case in <- Prioritized[int]{Value: 4, Priority: 0}:
+		t.Fatal("a full stage should push back")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(in)
+
+	var got []int
+	for v := range out {
+		got = append(got, v)
+	}
+	assert.Equal(t, []int{1, 3, 0, 2}, got)
+}
+
+func TestPriorityStageCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan Prioritized[int])
+	out := PriorityStage(ctx, in, PriorityOptions{Levels: 2, Capacity: 4})
+	in <- Prioritized[int]{Value: 1}
+	cancel()
+	for range out {
+	}
+}
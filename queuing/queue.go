@@ -0,0 +1,279 @@
+package queuing
+
+import (
+	"context"
+	"errors"
+	"patterns/clock"
+	"sync"
+	"time"
+)
+
+// Zen: A buffered channel is a queue, but a mute one: when it is full, the sender blocks, and
+// there's no telling how deep it is or how long items wait in it. Queue decouples two stages the
+// same way, and lets the caller decide what a full queue means: blocking the stage above, which
+// is backpressure, dropping items, which favours fresh data over complete data, or rejecting
+// them, which leaves the decision to the sender. Its depth and wait times show whether the queue
+// is absorbing bursts, as it should, or hiding a stage that is just too slow.
+
+// Overflow is what a full queue does with an item pushed to it
+type Overflow int
+
+const (
+	// Block waits for room in the queue, pushing back on the sender
+	Block Overflow = iota
+	// DropNewest discards the item pushed
+	DropNewest
+	// DropOldest discards the item that has waited the longest to make room for the one pushed
+	DropOldest
+	// Reject fails the push with ErrQueueFull
+	Reject
+)
+
+var (
+	// ErrQueueFull is returned for an item pushed to a full queue whose overflow is Reject
+	ErrQueueFull = errors.New("queue is full")
+	// ErrQueueClosed is returned for an item pushed to a closed queue, and for a pop from a
+	// closed queue once it is empty
+	ErrQueueClosed = errors.New("queue is closed")
+)
+
+// QueueOptions configure a queue
+type QueueOptions struct {
+	// Capacity is the number of items the queue holds, at least 1
+	Capacity int
+	// Overflow is what the queue does with an item pushed to it when it's full
+	Overflow Overflow
+	// Clock is the source of time for the wait times, defaults to the wall clock
+	Clock clock.Clock
+}
+
+// QueueStats are the counters of a queue, as of the time they were taken
+type QueueStats struct {
+	Capacity int
+	// Depth is the number of items in the queue
+	Depth int
+	// MaxDepth is the highest depth the queue has reached
+	MaxDepth int
+	// Pushed is the number of items that made it into the queue
+	Pushed int64
+	// Popped is the number of items that left it through a pop
+	Popped int64
+	// Dropped is the number of items discarded, either on their way in or to make room
+	Dropped int64
+	// Rejected is the number of pushes that failed with ErrQueueFull
+	Rejected int64
+	// MeanWait is the mean time popped items spent in the queue
+	MeanWait time.Duration
+	// MaxWait is the longest time a popped item spent in the queue
+	MaxWait time.Duration
+}
+
+type entry[T any] struct {
+	value    T
+	enqueued time.Time
+}
+
+// Queue is a bounded FIFO queue between stages. It is safe for concurrent use.
+type Queue[T any] struct {
+	overflow Overflow
+	clk      clock.Clock
+	meter    *Meter
+
+	mu     sync.Mutex
+	buf    []entry[T]
+	head   int
+	depth  int
+	closed bool
+	// changed is closed and replaced every time an item goes in or out, or the queue is
+	// closed, so that blocked pushes and pops can wait for it alongside their context
+	changed   chan struct{}
+	stats     QueueStats
+	totalWait time.Duration
+}
+
+// NewQueue returns an empty queue
+func NewQueue[T any](opts QueueOptions) *Queue[T] {
+	if opts.Capacity < 1 {
+		opts.Capacity = 1
+	}
+	clk := opts.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
+	return &Queue[T]{
+		overflow: opts.Overflow,
+		clk:      clk,
+		meter:    NewMeter(clk),
+		buf:      make([]entry[T], opts.Capacity),
+		changed:  make(chan struct{}),
+		stats:    QueueStats{Capacity: opts.Capacity},
+	}
+}
+
+// Push adds v to the back of the queue, handling a full queue as per its overflow. Only a
+// blocking push waits, until there's room or ctx is done.
+func (q *Queue[T]) Push(ctx context.Context, v T) error {
+	q.mu.Lock()
+	for {
+		if q.closed {
+			q.mu.Unlock()
+			return ErrQueueClosed
+		}
+		if q.depth < len(q.buf) {
+			break
+		}
+		switch q.overflow {
+		case DropNewest:
+			q.stats.Dropped++
+			q.mu.Unlock()
+			return nil
+		case DropOldest:
+			q.pop()
+			q.stats.Dropped++
+		case Reject:
+			q.stats.Rejected++
+			q.mu.Unlock()
+			return ErrQueueFull
+		default:
+			changed := q.changed
+			q.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-changed:
+			}
+			q.mu.Lock()
+		}
+	}
+	q.buf[(q.head+q.depth)%len(q.buf)] = entry[T]{value: v, enqueued: q.meter.Arrive()}
+	q.depth++
+	q.stats.Pushed++
+	if q.depth > q.stats.MaxDepth {
+		q.stats.MaxDepth = q.depth
+	}
+	q.notify()
+	q.mu.Unlock()
+	return nil
+}
+
+// Pop removes the item at the front of the queue, waiting for one until ctx is done. Once the
+// queue is closed, the items left are still popped before ErrQueueClosed is returned.
+func (q *Queue[T]) Pop(ctx context.Context) (T, error) {
+	q.mu.Lock()
+	for q.depth == 0 {
+		if q.closed {
+			q.mu.Unlock()
+			var zero T
+			return zero, ErrQueueClosed
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		case <-changed:
+		}
+		q.mu.Lock()
+	}
+	e := q.pop()
+	wait := q.clk.Since(e.enqueued)
+	q.meter.Depart(e.enqueued)
+	q.stats.Popped++
+	q.totalWait += wait
+	if wait > q.stats.MaxWait {
+		q.stats.MaxWait = wait
+	}
+	q.notify()
+	q.mu.Unlock()
+	return e.value, nil
+}
+
+// Close stops the queue from taking more items. Those already in it can still be popped.
+func (q *Queue[T]) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		q.notify()
+	}
+}
+
+// Len returns the number of items in the queue
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.depth
+}
+
+// Stats returns the counters of the queue
+func (q *Queue[T]) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := q.stats
+	stats.Depth = q.depth
+	if stats.Popped > 0 {
+		stats.MeanWait = q.totalWait / time.Duration(stats.Popped)
+	}
+	return stats
+}
+
+// Rates returns the arrival and departure rates of the queue, and the time items wait in it,
+// from which RecommendBuffer sizes its capacity. The queue does no work on its items, so its
+// service rate is left to the stage popping from it to measure.
+func (q *Queue[T]) Rates() Rates {
+	return q.meter.Rates()
+}
+
+// Stage drops the queue between two pipeline stages: it pushes every item of in to the queue
+// and emits them on the returned channel as they are popped. The queue is closed once in is, and
+// the channel once the queue has been drained. Items the queue drops or rejects are only counted
+// in its stats. Cancelling ctx stops the stage, leaving what's left in the queue.
+func (q *Queue[T]) Stage(ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer q.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if err := q.Push(ctx, v); err != nil && err != ErrQueueFull {
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		defer close(out)
+		for {
+			v, err := q.Pop(ctx)
+			if err != nil {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- v:
+			}
+		}
+	}()
+	return out
+}
+
+// pop removes the item at the front, the caller holding the lock and knowing there is one
+func (q *Queue[T]) pop() entry[T] {
+	e := q.buf[q.head]
+	q.buf[q.head] = entry[T]{}
+	q.head = (q.head + 1) % len(q.buf)
+	q.depth--
+	return e
+}
+
+func (q *Queue[T]) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
@@ -0,0 +1,146 @@
+package queuing
+
+import (
+	"context"
+	"patterns/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+// drain pops everything left in a closed queue
+func drain[T any](t *testing.T, q *Queue[T]) []T {
+	var out []T
+	for {
+		v, err := q.Pop(context.Background())
+		if err != nil {
+			assert.ErrorIs(t, err, ErrQueueClosed)
+			return out
+		}
+		out = append(out, v)
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		overflow Overflow
+		want     []int
+		dropped  int64
+		rejected int64
+	}{
+		{overflow: DropNewest, want: []int{1, 2}, dropped: 2},
+		{overflow: DropOldest, want: []int{3, 4}, dropped: 2},
+		{overflow: Reject, want: []int{1, 2}, rejected: 2},
+	} {
+		q := NewQueue[int](QueueOptions{Capacity: 2, Overflow: tc.overflow})
+		for i := 1; i <= 4; i++ {
+			err := q.Push(ctx, i)
+			if tc.overflow == Reject && i > 2 {
+				assert.ErrorIs(t, err, ErrQueueFull)
+			} else {
+				assert.NoError(t, err)
+			}
+		}
+		q.Close()
+		assert.ErrorIs(t, q.Push(ctx, 5), ErrQueueClosed)
+		assert.Equal(t, tc.want, drain(t, q))
+		stats := q.Stats()
+		assert.Equal(t, tc.dropped, stats.Dropped)
+		assert.Equal(t, tc.rejected, stats.Rejected)
+	}
+}
+
+func TestQueueBlocks(t *testing.T) {
+	q := NewQueue[int](QueueOptions{Capacity: 1})
+	assert.NoError(t, q.Push(context.Background(), 1))
+
+	// the push waits for room, until its context gives up
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.Push(ctx, 2), context.DeadlineExceeded)
+
+	pushed := make(chan error)
+	go func() {
+		pushed <- q.Push(context.Background(), 3)
+	}()
+	v, err := q.Pop(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, v)
+	assert.NoError(t, <-pushed)
+	q.Close()
+	assert.Equal(t, []int{3}, drain(t, q))
+}
+
+func TestQueuePopWaits(t *testing.T) {
+	q := NewQueue[int](QueueOptions{Capacity: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := q.Pop(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	popped := make(chan int)
+	go func() {
+		v, _ := q.Pop(context.Background())
+		popped <- v
+	}()
+	assert.NoError(t, q.Push(context.Background(), 7))
+	assert.Equal(t, 7, <-popped)
+}
+
+func TestQueueStats(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	q := NewQueue[string](QueueOptions{Capacity: 3, Clock: clk})
+	ctx := context.Background()
+
+	_ = q.Push(ctx, "a")
+	clk.Advance(time.Second)
+	_ = q.Push(ctx, "b")
+	_ = q.Push(ctx, "c")
+	clk.Advance(time.Second)
+	_, _ = q.Pop(ctx) // waited 2s
+	_, _ = q.Pop(ctx) // waited 1s
+
+	assert.Equal(t, QueueStats{
+		Capacity: 3, Depth: 1, MaxDepth: 3, Pushed: 3, Popped: 2,
+		MeanWait: 1500 * time.Millisecond, MaxWait: 2 * time.Second,
+	}, q.Stats())
+	assert.Equal(t, 1, q.Len())
+}
+
+func TestQueueStage(t *testing.T) {
+	ctx := context.Background()
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 10; i++ {
+			in <- i
+		}
+	}()
+	q := NewQueue[int](QueueOptions{Capacity: 4})
+	var out []int
+	for v := range q.Stage(ctx, in) {
+		out = append(out, v)
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, out)
+	assert.Equal(t, int64(10), q.Stats().Popped)
+}
+
+func TestQueueStageCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	q := NewQueue[int](QueueOptions{Capacity: 4})
+	out := q.Stage(ctx, in)
+	in <- 1
+	assert.Equal(t, 1, <-out)
+	cancel()
+	// the stage stops even though in is never closed
+	for range out {
+	}
+}
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
@@ -0,0 +1,629 @@
+package queuing
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Zen: An in-memory queue that fills up can only block its sender or drop data, and a stalled
+// stage below makes it do either for as long as it stalls. Disk is slower, but it is large and
+// it outlives the process. A spill queue keeps items in memory while there is room, and once
+// there isn't, appends them to segment files on disk and replays them in order as it drains.
+// Writes to disk are sequential and segments are deleted as a whole once read, which is what
+// makes disk cheap enough here, the same way buffered writes are cheaper than unbuffered ones.
+// For the same reason, how far the disk has been read is only saved every so often: a crash
+// then replays the items popped since, which are delivered at least once rather than exactly
+// once. Nothing is synced either, so this survives the process crashing, not the machine.
+
+// Codec turns items into bytes and back, so that they can be spilled to disk
+type Codec[T any] interface {
+	Marshal(v T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// GobCodec encodes items with encoding/gob
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Marshal(v T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (GobCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// JSONCodec encodes items with encoding/json
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Marshal(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+const (
+	// DefaultSegmentSize is the size past which a segment file is no longer appended to
+	DefaultSegmentSize = 4 << 20
+	// DefaultCommitEvery is the number of items popped from disk between two saves of how far
+	// it has been read
+	DefaultCommitEvery = 256
+	manifestFile       = "manifest.json"
+	// every record is its length as a big endian uint32, followed by the encoded item
+	recordHeader = 4
+)
+
+// SpillOptions configure a spill queue
+type SpillOptions[T any] struct {
+	// Dir is the directory the segments are kept in, one queue per directory
+	Dir string
+	// Memory is the number of items held in memory before spilling to disk
+	Memory int
+	// SegmentSize is the size in bytes past which a new segment is started
+	SegmentSize int64
+	// Codec encodes the items spilled, defaults to GobCodec
+	Codec Codec[T]
+	// CommitEvery is the number of items popped from disk after which how far it has been read
+	// is saved, which also happens whenever a segment has been read through and on Close. Up
+	// to that many items are popped again after a crash.
+	CommitEvery int
+}
+
+// segment is a segment file, and how far it has been read
+type segment struct {
+	ID     int64 `json:"id"`
+	Offset int64 `json:"offset"`
+}
+
+// manifest lists the segments in the order they are read, and is rewritten every CommitEvery
+// reads, so that a restart resumes at most that many items before the last one popped
+type manifest struct {
+	Segments []segment `json:"segments"`
+	NextID   int64     `json:"next_id"`
+}
+
+// SpillQueue is an unbounded FIFO queue which spills to disk once its memory is full. It is
+// safe for concurrent use, though only one goroutine should pop from it at a time.
+type SpillQueue[T any] struct {
+	opts SpillOptions[T]
+
+	mu       sync.Mutex
+	memory   []T
+	manifest manifest
+	onDisk   int
+	// writer appends to the last segment, reader reads the first one
+	writer     *os.File
+	writerSize int64
+	reader     *os.File
+	// peeked is the item at the front of the disk, read but not yet committed
+	peeked     *T
+	peekedSize int64
+	// uncommitted is the number of items popped from disk since the manifest was last saved
+	uncommitted int
+	closed      bool
+	inputDone   bool
+	changed     chan struct{}
+	// stageErr is the error which stopped Stage, if any
+	stageErr error
+}
+
+// OpenSpillQueue opens the queue kept in opts.Dir, creating it if need be. Items left unread
+// when it was last closed are popped first, in order. After a crash, that includes the items
+// popped since the manifest was last saved, and the items held in memory are lost. A record cut
+// short by a crash while it was being written is discarded.
+func OpenSpillQueue[T any](opts SpillOptions[T]) (*SpillQueue[T], error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.Codec == nil {
+		opts.Codec = GobCodec[T]{}
+	}
+	if opts.CommitEvery <= 0 {
+		opts.CommitEvery = DefaultCommitEvery
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	q := &SpillQueue[T]{opts: opts, changed: make(chan struct{})}
+	data, err := os.ReadFile(filepath.Join(opts.Dir, manifestFile))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &q.manifest); err != nil {
+			return nil, fmt.Errorf("spill queue %v: corrupt manifest: %w", opts.Dir, err)
+		}
+	}
+	if err := q.removeOrphans(); err != nil {
+		return nil, err
+	}
+	for _, s := range q.manifest.Segments {
+		n, err := q.recover(s)
+		if err != nil {
+			return nil, err
+		}
+		q.onDisk += n
+	}
+	return q, nil
+}
+
+// removeOrphans deletes the segments the manifest doesn't list, left behind by a crash between
+// creating a segment and saving the manifest listing it, and moves NextID past every segment
+// found, so that no new segment is ever created over an existing one
+func (q *SpillQueue[T]) removeOrphans() error {
+	paths, err := filepath.Glob(filepath.Join(q.opts.Dir, "segment-*.log"))
+	if err != nil {
+		return err
+	}
+	listed := make(map[int64]bool, len(q.manifest.Segments))
+	for _, s := range q.manifest.Segments {
+		listed[s.ID] = true
+	}
+	for _, path := range paths {
+		var id int64
+		if _, err := fmt.Sscanf(filepath.Base(path), "segment-%d.log", &id); err != nil {
+			continue
+		}
+		if id >= q.manifest.NextID {
+			q.manifest.NextID = id + 1
+		}
+		if !listed[id] {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// recover counts the records left to read in a segment, and truncates a record cut short
+func (q *SpillQueue[T]) recover(s segment) (int, error) {
+	f, err := os.OpenFile(q.segmentPath(s.ID), os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	offset, err := f.Seek(s.Offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	header := make([]byte, recordHeader)
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			if err == io.EOF {
+				return count, nil
+			}
+			return count, f.Truncate(offset)
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		end, err := f.Seek(size, io.SeekCurrent)
+		if err != nil {
+			return count, err
+		}
+		if info, err := f.Stat(); err != nil || end > info.Size() {
+			return count, f.Truncate(offset)
+		}
+		offset = end
+		count++
+	}
+}
+
+// Push adds v to the back of the queue, in memory if there's room and nothing is on disk yet,
+// or on disk otherwise. It never blocks.
+func (q *SpillQueue[T]) Push(v T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed || q.inputDone {
+		return ErrQueueClosed
+	}
+	// once anything is on disk, new items go after it, so that they come out in order
+	if q.onDisk == 0 && len(q.memory) < q.opts.Memory {
+		q.memory = append(q.memory, v)
+		q.notify()
+		return nil
+	}
+	if err := q.spill(v); err != nil {
+		return err
+	}
+	q.onDisk++
+	q.notify()
+	return nil
+}
+
+// Pop removes the item at the front of the queue, waiting for one until ctx is done. It returns
+// ErrQueueClosed once the queue is closed, or empty and done with its input.
+func (q *SpillQueue[T]) Pop(ctx context.Context) (T, error) {
+	v, err := q.peek(ctx)
+	if err != nil {
+		return v, err
+	}
+	return v, q.commit()
+}
+
+// Len returns the number of items in the queue
+func (q *SpillQueue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.memory) + q.onDisk
+}
+
+// Spilled returns the number of items on disk
+func (q *SpillQueue[T]) Spilled() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.onDisk
+}
+
+// Close writes the items in memory to disk, ahead of those already there, saves how far the disk
+// has been read, and releases the files. The queue can then be opened again to pop what's left.
+func (q *SpillQueue[T]) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	q.notify()
+
+	var errs []error
+	switch {
+	case len(q.memory) > 0:
+		errs = append(errs, q.persistMemory())
+	case q.uncommitted > 0:
+		errs = append(errs, q.saveManifest())
+	}
+	for _, f := range []*os.File{q.reader, q.writer} {
+		if f != nil {
+			errs = append(errs, f.Close())
+		}
+	}
+	q.reader, q.writer = nil, nil
+	return errors.Join(errs...)
+}
+
+// Stage drops the queue between two pipeline stages, so that a stalled stage below spills the
+// items of in to disk rather than pushing back on the stage above. An item is only removed from
+// the queue once the stage below has taken it. Once in is closed and the queue drained, the
+// returned channel is closed. Cancelling ctx stops the stage; the queue is then closed, keeping
+// the items left on disk for the next time it is opened.
+//
+// Should an item fail to spill, the items queued before it are still handed over, but it and
+// the items after it are read from in and discarded, so that the stage above is never blocked.
+// Err then returns why, and the caller should stop the stage above.
+func (q *SpillQueue[T]) Stage(ctx context.Context, in <-chan T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer q.endInput()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if err := q.Push(v); err != nil {
+					if ctx.Err() == nil {
+						q.fail(fmt.Errorf("spill queue %v: stage stopped, items discarded: %w", q.opts.Dir, err))
+					}
+					q.endInput()
+					discard(ctx, in)
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		defer close(out)
+		defer q.Close()
+		for {
+			v, err := q.peek(ctx)
+			if err != nil {
+				if !errors.Is(err, ErrQueueClosed) && ctx.Err() == nil {
+					q.fail(err)
+				}
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- v:
+				if err := q.commit(); err != nil {
+					q.fail(err)
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Err returns the error which stopped Stage, or nil if it stopped because in was closed or its
+// context was cancelled
+func (q *SpillQueue[T]) Err() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stageErr
+}
+
+func (q *SpillQueue[T]) fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stageErr == nil {
+		q.stageErr = err
+	}
+}
+
+// discard reads in until it is closed or ctx is done
+func discard[T any](ctx context.Context, in <-chan T) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-in:
+			if !ok {
+				return
+			}
+		}
+	}
+}
+
+// peek returns the item at the front of the queue without removing it, waiting for one
+func (q *SpillQueue[T]) peek(ctx context.Context) (T, error) {
+	var zero T
+	q.mu.Lock()
+	for len(q.memory) == 0 && q.onDisk == 0 {
+		if q.closed || q.inputDone {
+			q.mu.Unlock()
+			return zero, ErrQueueClosed
+		}
+		changed := q.changed
+		q.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case <-changed:
+		}
+		q.mu.Lock()
+	}
+	defer q.mu.Unlock()
+	if q.closed {
+		return zero, ErrQueueClosed
+	}
+	// items in memory are always older than those on disk
+	if len(q.memory) > 0 {
+		return q.memory[0], nil
+	}
+	if q.peeked == nil {
+		if err := q.readNext(); err != nil {
+			return zero, err
+		}
+	}
+	return *q.peeked, nil
+}
+
+// commit removes the item returned by the last peek. Reads from disk are only saved to the
+// manifest every CommitEvery items, see saveManifest for the others.
+func (q *SpillQueue[T]) commit() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if len(q.memory) > 0 {
+		var zero T
+		q.memory[0] = zero
+		q.memory = q.memory[1:]
+		return nil
+	}
+	q.manifest.Segments[0].Offset += q.peekedSize
+	q.peeked = nil
+	q.onDisk--
+	if q.onDisk == 0 {
+		// the disk is drained, start over with no segment at all
+		return q.reset()
+	}
+	if q.uncommitted++; q.uncommitted < q.opts.CommitEvery {
+		return nil
+	}
+	return q.saveManifest()
+}
+
+// readNext reads the record at the front of the disk into peeked, moving on to the next
+// segment when the first one has been read through
+func (q *SpillQueue[T]) readNext() error {
+	for {
+		if q.reader == nil {
+			f, err := os.Open(q.segmentPath(q.manifest.Segments[0].ID))
+			if err != nil {
+				return err
+			}
+			if _, err := f.Seek(q.manifest.Segments[0].Offset, io.SeekStart); err != nil {
+				f.Close()
+				return err
+			}
+			q.reader = f
+		}
+		header := make([]byte, recordHeader)
+		if _, err := io.ReadFull(q.reader, header); err != nil {
+			if err != io.EOF {
+				return err
+			}
+			// there are items left on disk, hence in the segments after this one
+			if err := q.dropFirstSegment(); err != nil {
+				return err
+			}
+			continue
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header))
+		if _, err := io.ReadFull(q.reader, data); err != nil {
+			return err
+		}
+		v, err := q.opts.Codec.Unmarshal(data)
+		if err != nil {
+			return fmt.Errorf("spill queue %v: corrupt record: %w", q.opts.Dir, err)
+		}
+		q.peeked, q.peekedSize = &v, int64(recordHeader+len(data))
+		return nil
+	}
+}
+
+// spill appends v to the last segment, starting a new one if it is full
+func (q *SpillQueue[T]) spill(v T) error {
+	data, err := q.opts.Codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if q.writer == nil || q.writerSize >= q.opts.SegmentSize {
+		if err := q.openWriter(); err != nil {
+			return err
+		}
+	}
+	record := make([]byte, recordHeader+len(data))
+	binary.BigEndian.PutUint32(record, uint32(len(data)))
+	copy(record[recordHeader:], data)
+	// a single write, so that a reader never sees a header without its item
+	n, err := q.writer.Write(record)
+	q.writerSize += int64(n)
+	return err
+}
+
+// openWriter opens the last segment for appending, unless it is full, in which case it starts
+// a new one
+func (q *SpillQueue[T]) openWriter() error {
+	if q.writer != nil {
+		if err := q.writer.Close(); err != nil {
+			return err
+		}
+		q.writer = nil
+	}
+	if n := len(q.manifest.Segments); n > 0 {
+		path := q.segmentPath(q.manifest.Segments[n-1].ID)
+		if info, err := os.Stat(path); err == nil && info.Size() < q.opts.SegmentSize {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				return err
+			}
+			q.writer, q.writerSize = f, info.Size()
+			return nil
+		}
+	}
+	s := segment{ID: q.manifest.NextID}
+	f, err := os.OpenFile(q.segmentPath(s.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	q.manifest.NextID++
+	q.manifest.Segments = append(q.manifest.Segments, s)
+	q.writer, q.writerSize = f, 0
+	return q.saveManifest()
+}
+
+// persistMemory writes the items in memory to a new segment put in front of the others
+func (q *SpillQueue[T]) persistMemory() error {
+	s := segment{ID: q.manifest.NextID}
+	var buf bytes.Buffer
+	for _, v := range q.memory {
+		data, err := q.opts.Codec.Marshal(v)
+		if err != nil {
+			return err
+		}
+		header := make([]byte, recordHeader)
+		binary.BigEndian.PutUint32(header, uint32(len(data)))
+		buf.Write(header)
+		buf.Write(data)
+	}
+	if err := os.WriteFile(q.segmentPath(s.ID), buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	q.manifest.NextID++
+	q.manifest.Segments = append([]segment{s}, q.manifest.Segments...)
+	q.onDisk += len(q.memory)
+	q.memory = nil
+	return q.saveManifest()
+}
+
+// dropFirstSegment deletes the first segment, which has been read through
+func (q *SpillQueue[T]) dropFirstSegment() error {
+	if err := q.reader.Close(); err != nil {
+		return err
+	}
+	q.reader = nil
+	id := q.manifest.Segments[0].ID
+	q.manifest.Segments = q.manifest.Segments[1:]
+	if err := q.saveManifest(); err != nil {
+		return err
+	}
+	return os.Remove(q.segmentPath(id))
+}
+
+// reset deletes every segment once the disk has been drained
+func (q *SpillQueue[T]) reset() error {
+	var errs []error
+	for _, f := range []*os.File{q.reader, q.writer} {
+		if f != nil {
+			errs = append(errs, f.Close())
+		}
+	}
+	q.reader, q.writer = nil, nil
+	segments := q.manifest.Segments
+	q.manifest.Segments = nil
+	errs = append(errs, q.saveManifest())
+	for _, s := range segments {
+		errs = append(errs, os.Remove(q.segmentPath(s.ID)))
+	}
+	return errors.Join(errs...)
+}
+
+// saveManifest replaces the manifest in one go, so that a crash leaves either the old or the new
+// one. Whatever it is saved for, the reads since the last save are saved along.
+func (q *SpillQueue[T]) saveManifest() error {
+	data, err := json.Marshal(q.manifest)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(q.opts.Dir, manifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(q.opts.Dir, manifestFile)); err != nil {
+		return err
+	}
+	q.uncommitted = 0
+	return nil
+}
+
+func (q *SpillQueue[T]) segmentPath(id int64) string {
+	return filepath.Join(q.opts.Dir, fmt.Sprintf("segment-%020d.log", id))
+}
+
+// endInput marks that nothing more will be pushed, so that pops return once the queue is drained
+func (q *SpillQueue[T]) endInput() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.inputDone = true
+	q.notify()
+}
+
+func (q *SpillQueue[T]) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
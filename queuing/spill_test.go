@@ -0,0 +1,276 @@
+package queuing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type event struct {
+	ID   int
+	Name string
+}
+
+func openSpill[T any](t *testing.T, opts SpillOptions[T]) *SpillQueue[T] {
+	q, err := OpenSpillQueue(opts)
+	require.NoError(t, err)
+	return q
+}
+
+func popN[T any](t *testing.T, q *SpillQueue[T], n int) []T {
+	var out []T
+	for i := 0; i < n; i++ {
+		v, err := q.Pop(context.Background())
+		require.NoError(t, err)
+		out = append(out, v)
+	}
+	return out
+}
+
+func segments(t *testing.T, dir string) int {
+	matches, err := filepath.Glob(filepath.Join(dir, "segment-*.log"))
+	require.NoError(t, err)
+	return len(matches)
+}
+
+func TestSpillQueueSpillsAndReplaysInOrder(t *testing.T) {
+	dir := t.TempDir()
+	// segments this small hold a couple of items at most
+	q := openSpill(t, SpillOptions[int]{Dir: dir, Memory: 2, SegmentSize: 32})
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, q.Push(i))
+	}
+	assert.Equal(t, 10, q.Len())
+	assert.Equal(t, 8, q.Spilled())
+	assert.Greater(t, segments(t, dir), 1)
+
+	assert.Equal(t, []int{0, 1, 2, 3, 4}, popN(t, q, 5))
+	// once something is on disk, new items queue up behind it
+	require.NoError(t, q.Push(10))
+	assert.Equal(t, []int{5, 6, 7, 8, 9, 10}, popN(t, q, 6))
+
+	// segments are deleted once read, and memory is used again once the disk is drained
+	assert.Equal(t, 0, segments(t, dir))
+	require.NoError(t, q.Push(11))
+	assert.Equal(t, 0, q.Spilled())
+	require.NoError(t, q.Close())
+}
+
+func TestSpillQueueRecoversAfterRestart(t *testing.T) {
+	for name, codec := range map[string]Codec[event]{"gob": GobCodec[event]{}, "json": JSONCodec[event]{}} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := SpillOptions[event]{Dir: dir, Memory: 3, SegmentSize: 64, Codec: codec}
+			q := openSpill(t, opts)
+			for i := 0; i < 8; i++ {
+				require.NoError(t, q.Push(event{ID: i, Name: "e"}))
+			}
+			assert.Equal(t, []event{{0, "e"}, {1, "e"}}, popN(t, q, 2))
+			// the item left in memory goes to disk, ahead of those already there
+			require.NoError(t, q.Close())
+			_, err := q.Pop(context.Background())
+			assert.ErrorIs(t, err, ErrQueueClosed)
+			assert.ErrorIs(t, q.Push(event{}), ErrQueueClosed)
+
+			q = openSpill(t, opts)
+			assert.Equal(t, 6, q.Len())
+			assert.Equal(t, []event{{2, "e"}, {3, "e"}, {4, "e"}}, popN(t, q, 3))
+			require.NoError(t, q.Push(event{ID: 8, Name: "e"}))
+			require.NoError(t, q.Close())
+
+			q = openSpill(t, opts)
+			assert.Equal(t, []event{{5, "e"}, {6, "e"}, {7, "e"}, {8, "e"}}, popN(t, q, 4))
+			assert.Equal(t, 0, q.Len())
+			require.NoError(t, q.Close())
+		})
+	}
+}
+
+// crash releases the files of q without closing it, as a process crashing would
+func crash[T any](t *testing.T, q *SpillQueue[T]) {
+	for _, f := range []*os.File{q.reader, q.writer} {
+		if f != nil {
+			require.NoError(t, f.Close())
+		}
+	}
+}
+
+func TestSpillQueueReplaysUncommittedPopsAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	opts := SpillOptions[int]{Dir: dir, SegmentSize: 1 << 10, CommitEvery: 4}
+	q := openSpill(t, opts)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, q.Push(i))
+	}
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, popN(t, q, 6))
+	crash(t, q)
+
+	// only the first four pops were saved, the next two are popped again
+	q = openSpill(t, opts)
+	assert.Equal(t, 6, q.Len())
+	assert.Equal(t, []int{4, 5, 6}, popN(t, q, 3))
+	// whereas Close saves every pop
+	require.NoError(t, q.Close())
+	q = openSpill(t, opts)
+	assert.Equal(t, []int{7, 8, 9}, popN(t, q, 3))
+	require.NoError(t, q.Close())
+}
+
+func TestSpillQueueDiscardsRecordCutShort(t *testing.T) {
+	dir := t.TempDir()
+	opts := SpillOptions[int]{Dir: dir}
+	q := openSpill(t, opts)
+	require.NoError(t, q.Push(1))
+	require.NoError(t, q.Push(2))
+	require.NoError(t, q.Close())
+
+	// a crash while writing the third item leaves half a record behind
+	f, err := os.OpenFile(q.segmentPath(q.manifest.Segments[len(q.manifest.Segments)-1].ID), os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 9, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	q = openSpill(t, opts)
+	assert.Equal(t, 2, q.Len())
+	require.NoError(t, q.Push(3))
+	assert.Equal(t, []int{1, 2, 3}, popN(t, q, 3))
+	require.NoError(t, q.Close())
+}
+
+func TestSpillQueuePopWaits(t *testing.T) {
+	q := openSpill(t, SpillOptions[int]{Dir: t.TempDir(), Memory: 1})
+	defer q.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := q.Pop(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	popped := make(chan int)
+	go func() {
+		v, _ := q.Pop(context.Background())
+		popped <- v
+	}()
+	require.NoError(t, q.Push(7))
+	assert.Equal(t, 7, <-popped)
+}
+
+func TestSpillQueueStage(t *testing.T) {
+	dir := t.TempDir()
+	opts := SpillOptions[int]{Dir: dir, Memory: 4, SegmentSize: 64}
+	ctx, cancel := context.WithCancel(context.Background())
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 100; i++ {
+			in <- i
+		}
+	}()
+	q := openSpill(t, opts)
+	out := q.Stage(ctx, in)
+
+	// the stage below stalls, the stage above doesn't
+	assert.Equal(t, 0, <-out)
+	assert.Eventually(t, func() bool { return q.Len() >= 98 }, time.Second, time.Millisecond)
+	assert.Greater(t, q.Spilled(), 0)
+	got := []int{0}
+	for len(got) < 50 {
+		got = append(got, <-out)
+	}
+	cancel()
+	// the stage may still hand over an item while stopping
+	for v := range out {
+		got = append(got, v)
+	}
+
+	// what the stage below never took is there for the next run
+	q = openSpill(t, opts)
+	for q.Len() > 0 {
+		got = append(got, popN(t, q, 1)...)
+	}
+	require.NoError(t, q.Close())
+	want := make([]int, 100)
+	for i := range want {
+		want[i] = i
+	}
+	assert.Equal(t, want, got)
+}
+
+// failingCodec fails to encode one item
+type failingCodec struct {
+	GobCodec[int]
+	fail int
+}
+
+func (c failingCodec) Marshal(v int) ([]byte, error) {
+	if v == c.fail {
+		return nil, errors.New("disk full")
+	}
+	return c.GobCodec.Marshal(v)
+}
+
+func TestSpillQueueStageReportsFailedSpill(t *testing.T) {
+	q := openSpill(t, SpillOptions[int]{Dir: t.TempDir(), Codec: failingCodec{fail: 3}})
+	in := make(chan int)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		defer close(in)
+		for i := 0; i < 10; i++ {
+			in <- i
+		}
+	}()
+
+	var got []int
+	for v := range q.Stage(context.Background(), in) {
+		got = append(got, v)
+	}
+	// the stage above isn't left blocked
+	<-sent
+	assert.Equal(t, []int{0, 1, 2}, got)
+	require.Error(t, q.Err())
+	assert.Contains(t, q.Err().Error(), "disk full")
+}
+
+func TestSpillQueueRemovesOrphanSegments(t *testing.T) {
+	dir := t.TempDir()
+	opts := SpillOptions[int]{Dir: dir, SegmentSize: 16}
+	q := openSpill(t, opts)
+	require.NoError(t, q.Push(1))
+	require.NoError(t, q.Close())
+
+	// a crash between creating a segment and saving the manifest leaves one unlisted
+	orphan := q.segmentPath(q.manifest.NextID)
+	require.NoError(t, os.WriteFile(orphan, nil, 0o644))
+
+	q = openSpill(t, opts)
+	assert.NoFileExists(t, orphan)
+	for i := 2; i <= 5; i++ {
+		require.NoError(t, q.Push(i))
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, popN(t, q, 5))
+	require.NoError(t, q.Close())
+}
+
+func BenchmarkSpillQueuePop(b *testing.B) {
+	q, err := OpenSpillQueue(SpillOptions[int]{Dir: b.TempDir()})
+	require.NoError(b, err)
+	defer q.Close()
+	// one more item than popped, so that the disk is never drained and reset
+	for i := 0; i <= b.N; i++ {
+		require.NoError(b, q.Push(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := q.Pop(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package replicated_requests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Zen: DoWork leaves the caller to wire the result channel, the wait group and the cancellation
+// by hand, and has no way to report a failed handler. Here, every replica is a function that
+// returns a value or an error. Replicas are all started at once, and as soon as enough of them
+// have replied, the rest are cancelled and waited for, so that no replica outlives the request.
+
+// ErrNoQuorum is returned when too few replicas succeed, or agree, for the request to be served
+var ErrNoQuorum = errors.New("not enough replicas succeeded")
+
+// FirstOf returns the value of the first replica to succeed. If every replica fails, it returns
+// the errors of all of them joined together.
+func FirstOf[T any](ctx context.Context, replicas ...func(context.Context) (T, error)) (T, error) {
+	values, err := FirstN(ctx, 1, replicas...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return values[0], nil
+}
+
+// FirstN returns the values of the first n replicas to succeed, in the order they succeeded.
+// As soon as n replicas can no longer succeed, it returns ErrNoQuorum joined with their errors.
+func FirstN[T any](ctx context.Context, n int, replicas ...func(context.Context) (T, error)) ([]T, error) {
+	if n <= 0 || n > len(replicas) {
+		return nil, fmt.Errorf("%w: want %d out of %d replicas", ErrNoQuorum, n, len(replicas))
+	}
+	replies, stop := replicate(ctx, replicas)
+	defer stop()
+
+	values := make([]T, 0, n)
+	var errs []error
+	for len(values) < n {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case r := <-replies:
+			if r.err != nil {
+				errs = append(errs, r.err)
+				if len(replicas)-len(errs) < n {
+					return nil, noQuorum(n, len(replicas), errs)
+				}
+				continue
+			}
+			values = append(values, r.value)
+		}
+	}
+	return values, nil
+}
+
+// Quorum returns the first value on which k of the replicas agree. It is the way to go when
+// replicas may return stale or wrong values, rather than just slow ones. As soon as no value
+// can reach k votes anymore, it returns ErrNoQuorum joined with the errors of the replicas.
+func Quorum[T comparable](ctx context.Context, k int, replicas ...func(context.Context) (T, error)) (T, error) {
+	var zero T
+	if k <= 0 || k > len(replicas) {
+		return zero, fmt.Errorf("%w: want %d out of %d replicas", ErrNoQuorum, k, len(replicas))
+	}
+	replies, stop := replicate(ctx, replicas)
+	defer stop()
+
+	votes := make(map[T]int)
+	var errs []error
+	for pending := len(replicas); pending > 0; pending-- {
+		select {
+		case <-ctx.Done():
+			return zero, ctx.Err()
+		case r := <-replies:
+			if r.err != nil {
+				errs = append(errs, r.err)
+				break
+			}
+			votes[r.value]++
+			if votes[r.value] >= k {
+				return r.value, nil
+			}
+		}
+		// give up early once even the leading value can't make it with the remaining replies
+		leading := 0
+		for _, v := range votes {
+			if v > leading {
+				leading = v
+			}
+		}
+		if leading+pending-1 < k {
+			break
+		}
+	}
+	return zero, noQuorum(k, len(replicas), errs)
+}
+
+type reply[T any] struct {
+	value T
+	err   error
+}
+
+// replicate starts every replica and returns their replies, along with a function that cancels
+// the replicas still running and waits for them to return
+func replicate[T any](ctx context.Context, replicas []func(context.Context) (T, error)) (<-chan reply[T], func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	// buffered for every replica, so that none of them blocks once we stop listening
+	replies := make(chan reply[T], len(replicas))
+	var wg sync.WaitGroup
+	wg.Add(len(replicas))
+	for _, replica := range replicas {
+		go func(replica func(context.Context) (T, error)) {
+			defer wg.Done()
+			v, err := replica(ctx)
+			replies <- reply[T]{value: v, err: err}
+		}(replica)
+	}
+	return replies, func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+func noQuorum(want, of int, errs []error) error {
+	return errors.Join(append([]error{fmt.Errorf("%w: want %d out of %d replicas", ErrNoQuorum, want, of)}, errs...)...)
+}
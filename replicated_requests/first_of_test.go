@@ -0,0 +1,111 @@
+package replicated_requests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+// replica replies with the given value or error after the delay, unless it is cancelled first
+func replica(delay time.Duration, v int, err error, cancelled *atomic.Int64) func(context.Context) (int, error) {
+	return func(ctx context.Context) (int, error) {
+		select {
+		case <-ctx.Done():
+			cancelled.Inc()
+			return 0, ctx.Err()
+		case <-time.After(delay):
+			return v, err
+		}
+	}
+}
+
+func TestFirstOf(t *testing.T) {
+	var cancelled atomic.Int64
+	v, err := FirstOf(context.Background(),
+		replica(time.Hour, 1, nil, &cancelled),
+		replica(time.Millisecond, 0, errors.New("fast but wrong"), &cancelled),
+		replica(10*time.Millisecond, 2, nil, &cancelled),
+		replica(time.Hour, 3, nil, &cancelled),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, v)
+	// the slow replicas have been cancelled and waited for by the time we return
+	assert.Equal(t, int64(2), cancelled.Load())
+}
+
+func TestFirstOfAllFail(t *testing.T) {
+	var cancelled atomic.Int64
+	errA, errB := errors.New("a"), errors.New("b")
+	_, err := FirstOf(context.Background(),
+		replica(time.Millisecond, 0, errA, &cancelled),
+		replica(2*time.Millisecond, 0, errB, &cancelled),
+	)
+	assert.ErrorIs(t, err, ErrNoQuorum)
+	assert.ErrorIs(t, err, errA)
+	assert.ErrorIs(t, err, errB)
+}
+
+func TestFirstOfCancelled(t *testing.T) {
+	var cancelled atomic.Int64
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := FirstOf(ctx, replica(time.Hour, 1, nil, &cancelled), replica(time.Hour, 2, nil, &cancelled))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int64(2), cancelled.Load())
+}
+
+func TestFirstN(t *testing.T) {
+	var cancelled atomic.Int64
+	values, err := FirstN(context.Background(), 2,
+		replica(20*time.Millisecond, 2, nil, &cancelled),
+		replica(time.Millisecond, 1, nil, &cancelled),
+		replica(time.Hour, 3, nil, &cancelled),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, values)
+	assert.Equal(t, int64(1), cancelled.Load())
+
+	// one failure is enough for two out of two to be impossible
+	errA := errors.New("a")
+	_, err = FirstN(context.Background(), 2,
+		replica(time.Millisecond, 0, errA, &cancelled),
+		replica(time.Hour, 1, nil, &cancelled),
+	)
+	assert.ErrorIs(t, err, ErrNoQuorum)
+	assert.ErrorIs(t, err, errA)
+
+	_, err = FirstN(context.Background(), 3, replica(time.Millisecond, 0, nil, &cancelled))
+	assert.EqualError(t, err, "not enough replicas succeeded: want 3 out of 1 replicas")
+}
+
+func TestQuorum(t *testing.T) {
+	var cancelled atomic.Int64
+	v, err := Quorum(context.Background(), 2,
+		replica(time.Millisecond, 1, nil, &cancelled), // a stale replica, replying first
+		replica(5*time.Millisecond, 2, nil, &cancelled),
+		replica(10*time.Millisecond, 2, nil, &cancelled),
+		replica(time.Hour, 2, nil, &cancelled),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, v)
+	assert.Equal(t, int64(1), cancelled.Load())
+}
+
+func TestQuorumNotReached(t *testing.T) {
+	var cancelled atomic.Int64
+	errA := errors.New("a")
+	// once two replicas disagree and one fails, the last one can't make a quorum of 3
+	_, err := Quorum(context.Background(), 3,
+		replica(time.Millisecond, 1, nil, &cancelled),
+		replica(2*time.Millisecond, 2, nil, &cancelled),
+		replica(3*time.Millisecond, 0, errA, &cancelled),
+		replica(time.Hour, 2, nil, &cancelled),
+	)
+	assert.ErrorIs(t, err, ErrNoQuorum)
+	assert.ErrorIs(t, err, errA)
+	assert.Equal(t, int64(1), cancelled.Load())
+}
@@ -0,0 +1,177 @@
+package replicated_requests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"patterns/clock"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Zen: Replicating every request is expensive, as it multiplies the load by the number of
+// replicas even though most requests are served just fine by the first one. Hedging only
+// replicates the slow ones: the first attempt is sent right away and a backup is sent only if
+// it hasn't replied within a delay, usually the p95 latency. This way, at the cost of a few
+// percent of extra load, the tail latency of a request approaches that of its fastest path.
+
+// ErrInvalidHedgeOptions is returned for options that can't make a hedged request
+var ErrInvalidHedgeOptions = errors.New("invalid hedge options")
+
+// HedgeOptions configure a hedged request
+type HedgeOptions struct {
+	// Delay is how long to wait for the attempts in flight before sending another one. It must
+	// be positive when hedges are allowed, else every hedge is sent at once, which is replicating.
+	Delay time.Duration
+	// MaxHedges caps the number of backup attempts sent after the first one, none if 0
+	MaxHedges int
+	// Clock is the source of time for the delay, defaults to the wall clock
+	Clock clock.Clock
+}
+
+// Hedged is the result of a hedged request
+type Hedged[T any] struct {
+	Value T
+	// Attempt is the attempt that won, the first one being 0 and hedges following from 1
+	Attempt int
+	// Attempts is the number of attempts sent in total
+	Attempts int
+}
+
+// Hedge calls fn right away, and again every time the delay elapses without a successful reply
+// until MaxHedges backups have been sent. Once every attempt in flight has failed, the next one
+// is sent right away instead of waiting for the delay, whereas a failure while others are still
+// in flight leaves them to it. The first successful attempt wins and the others are
+// cancelled and waited for before Hedge returns. If every attempt fails, the errors are joined.
+// Options with a negative MaxHedges, or hedges but no positive Delay, make it return
+// ErrInvalidHedgeOptions without calling fn.
+func Hedge[T any](ctx context.Context, fn func(ctx context.Context) (T, error), opts HedgeOptions) (Hedged[T], error) {
+	switch {
+	case opts.MaxHedges < 0:
+		return Hedged[T]{}, fmt.Errorf("%w: negative MaxHedges %d", ErrInvalidHedgeOptions, opts.MaxHedges)
+	case opts.MaxHedges > 0 && opts.Delay <= 0:
+		return Hedged[T]{}, fmt.Errorf("%w: Delay %v must be positive to hedge", ErrInvalidHedgeOptions, opts.Delay)
+	}
+	clk := opts.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
+	type attempt struct {
+		id    int
+		value T
+		err   error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	// buffered for every attempt, so that losers never block after we have returned
+	results := make(chan attempt, opts.MaxHedges+1)
+	launched := 0
+	launch := func() {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			v, err := fn(ctx)
+			results <- attempt{id: id, value: v, err: err}
+		}(launched)
+		launched++
+	}
+	// cancel the losers and wait for them, so that nothing outlives the request
+	defer wg.Wait()
+	defer cancel()
+
+	launch()
+	delay := clk.NewTimer(opts.Delay)
+	defer delay.Stop()
+	restartDelay := func() {
+		// a tick left behind by a timer that fired while we were busy would hedge too early
+		if !delay.Stop() {
+			select {
+			case <-delay.C():
+			default:
+			}
+		}
+		delay.Reset(opts.Delay)
+	}
+
+	var errs []error
+	for {
+		select {
+		case <-ctx.Done():
+			return Hedged[T]{Attempts: launched}, ctx.Err()
+		case <-delay.C():
+			if launched <= opts.MaxHedges {
+				launch()
+				restartDelay()
+			}
+		case r := <-results:
+			if r.err == nil {
+				return Hedged[T]{Value: r.value, Attempt: r.id, Attempts: launched}, nil
+			}
+			errs = append(errs, r.err)
+			if len(errs) < launched {
+				continue // others are still in flight
+			}
+			if launched > opts.MaxHedges {
+				return Hedged[T]{Attempts: launched}, errors.Join(errs...)
+			}
+			// there's no point waiting for the delay when nothing is in flight
+			launch()
+			restartDelay()
+		}
+	}
+}
+
+// LatencyRecorder keeps the most recent latencies of a request, so that hedges can be delayed
+// by a percentile of what is observed rather than a guess. It is safe for concurrent use.
+type LatencyRecorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+// NewLatencyRecorder returns a recorder that keeps the given number of most recent samples, at
+// least one
+func NewLatencyRecorder(size int) *LatencyRecorder {
+	if size < 1 {
+		size = 1
+	}
+	return &LatencyRecorder{samples: make([]time.Duration, size)}
+}
+
+// Observe records the latency of a request
+func (l *LatencyRecorder) Observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % len(l.samples)
+	l.full = l.full || l.next == 0
+}
+
+// Percentile returns the latency under which the given fraction (0 to 1) of the recorded
+// samples fall, or fallback if nothing has been recorded yet
+func (l *LatencyRecorder) Percentile(p float64, fallback time.Duration) time.Duration {
+	l.mu.Lock()
+	n := l.next
+	if l.full {
+		n = len(l.samples)
+	}
+	sorted := append([]time.Duration(nil), l.samples[:n]...)
+	l.mu.Unlock()
+
+	if len(sorted) == 0 {
+		return fallback
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	// nearest rank, clamped to the samples we have
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
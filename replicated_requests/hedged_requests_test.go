@@ -0,0 +1,203 @@
+package replicated_requests
+
+import (
+	"context"
+	"errors"
+	"patterns/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+)
+
+const hedgeDelay = 100 * time.Millisecond
+
+// scripted is a request whose attempts reply only when the test tells them to
+type scripted struct {
+	calls     atomic.Int64
+	cancelled atomic.Int64
+	started   chan int
+	replies   []chan error
+}
+
+func newScripted(attempts int) *scripted {
+	s := &scripted{started: make(chan int, attempts), replies: make([]chan error, attempts)}
+	for i := range s.replies {
+		s.replies[i] = make(chan error, 1)
+	}
+	return s
+}
+
+func (s *scripted) fn(ctx context.Context) (int, error) {
+	id := int(s.calls.Inc() - 1)
+	s.started <- id
+	select {
+	case err := <-s.replies[id]:
+		return id, err
+	case <-ctx.Done():
+		s.cancelled.Inc()
+		return 0, ctx.Err()
+	}
+}
+
+func hedgeAsync(ctx context.Context, s *scripted, opts HedgeOptions) <-chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := Hedge(ctx, s.fn, opts)
+		errCh <- err
+	}()
+	return errCh
+}
+
+func TestHedgeFirstAttemptWins(t *testing.T) {
+	s := newScripted(1)
+	s.replies[0] <- nil
+
+	hedged, err := Hedge(context.Background(), s.fn, HedgeOptions{Delay: hedgeDelay, MaxHedges: 2, Clock: clock.NewVirtual(time.Now())})
+	assert.NoError(t, err)
+	assert.Equal(t, Hedged[int]{Value: 0, Attempt: 0, Attempts: 1}, hedged)
+}
+
+func TestHedgeBackupWinsAfterDelay(t *testing.T) {
+	s := newScripted(2)
+	clk := clock.NewVirtual(time.Now())
+	var hedged Hedged[int]
+	finished := make(chan error)
+	go func() {
+		var err error
+		hedged, err = Hedge(context.Background(), s.fn, HedgeOptions{Delay: hedgeDelay, MaxHedges: 2, Clock: clk})
+		finished <- err
+	}()
+
+	assert.Equal(t, 0, <-s.started)
+	clk.BlockUntil(1)
+	clk.Advance(hedgeDelay - time.Millisecond)
+	assert.Len(t, s.started, 0) // still within the delay, no hedge yet
+	clk.Advance(time.Millisecond)
+	assert.Equal(t, 1, <-s.started)
+	s.replies[1] <- nil
+
+	assert.NoError(t, <-finished)
+	assert.Equal(t, Hedged[int]{Value: 1, Attempt: 1, Attempts: 2}, hedged)
+	// the loser has been cancelled and waited for by the time we return
+	assert.Equal(t, int64(1), s.cancelled.Load())
+}
+
+func TestHedgeIsCapped(t *testing.T) {
+	s := newScripted(2)
+	clk := clock.NewVirtual(time.Now())
+	var hedged Hedged[int]
+	finished := make(chan error)
+	go func() {
+		var err error
+		hedged, err = Hedge(context.Background(), s.fn, HedgeOptions{Delay: hedgeDelay, MaxHedges: 1, Clock: clk})
+		finished <- err
+	}()
+
+	<-s.started
+	clk.BlockUntil(1)
+	clk.Advance(hedgeDelay)
+	<-s.started
+	clk.BlockUntil(1)
+	clk.Advance(10 * hedgeDelay)
+	s.replies[0] <- nil
+
+	assert.NoError(t, <-finished)
+	assert.Equal(t, Hedged[int]{Value: 0, Attempt: 0, Attempts: 2}, hedged)
+}
+
+func TestHedgeFailureSendsNextAttemptRightAway(t *testing.T) {
+	s := newScripted(3)
+	errA, errB, errC := errors.New("a"), errors.New("b"), errors.New("c")
+	s.replies[0] <- errA
+	s.replies[1] <- errB
+	s.replies[2] <- errC
+
+	// the clock never moves, so attempts are only sent because the previous ones failed
+	hedged, err := Hedge(context.Background(), s.fn, HedgeOptions{Delay: hedgeDelay, MaxHedges: 2, Clock: clock.NewVirtual(time.Now())})
+	assert.Equal(t, 3, hedged.Attempts)
+	assert.ErrorIs(t, err, errA)
+	assert.ErrorIs(t, err, errB)
+	assert.ErrorIs(t, err, errC)
+}
+
+func TestHedgeFailureLeavesOthersInFlightToIt(t *testing.T) {
+	s := newScripted(3)
+	clk := clock.NewVirtual(time.Now())
+	var hedged Hedged[int]
+	finished := make(chan error)
+	go func() {
+		var err error
+		hedged, err = Hedge(context.Background(), s.fn, HedgeOptions{Delay: hedgeDelay, MaxHedges: 2, Clock: clk})
+		finished <- err
+	}()
+
+	<-s.started
+	clk.BlockUntil(1)
+	clk.Advance(hedgeDelay)
+	<-s.started
+	// the first attempt failing while the hedge is in flight sends no other
+	s.replies[0] <- errors.New("a")
+	s.replies[1] <- nil
+
+	assert.NoError(t, <-finished)
+	assert.Equal(t, Hedged[int]{Value: 1, Attempt: 1, Attempts: 2}, hedged)
+}
+
+func TestHedgeCancelled(t *testing.T) {
+	s := newScripted(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := hedgeAsync(ctx, s, HedgeOptions{Delay: hedgeDelay, Clock: clock.NewVirtual(time.Now())})
+
+	<-s.started
+	cancel()
+	assert.ErrorIs(t, <-errCh, context.Canceled)
+	assert.Equal(t, int64(1), s.cancelled.Load())
+}
+
+func TestHedgeInvalidOptions(t *testing.T) {
+	for name, opts := range map[string]HedgeOptions{
+		"negative hedges": {Delay: hedgeDelay, MaxHedges: -2},
+		"no delay":        {MaxHedges: 1},
+		"negative delay":  {Delay: -time.Second, MaxHedges: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := newScripted(1)
+			_, err := Hedge(context.Background(), s.fn, opts)
+			assert.ErrorIs(t, err, ErrInvalidHedgeOptions)
+			assert.Equal(t, int64(0), s.calls.Load())
+		})
+	}
+
+	// without hedges, there is no delay to wait for
+	s := newScripted(1)
+	s.replies[0] <- nil
+	hedged, err := Hedge(context.Background(), s.fn, HedgeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hedged.Attempts)
+}
+
+func TestLatencyRecorder(t *testing.T) {
+	latencies := NewLatencyRecorder(100)
+	assert.Equal(t, hedgeDelay, latencies.Percentile(0.95, hedgeDelay))
+
+	for i := 1; i <= 100; i++ {
+		latencies.Observe(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, 95*time.Millisecond, latencies.Percentile(0.95, hedgeDelay))
+	assert.Equal(t, 100*time.Millisecond, latencies.Percentile(1, hedgeDelay))
+
+	// only the most recent samples are kept
+	for i := 0; i < 100; i++ {
+		latencies.Observe(time.Millisecond)
+	}
+	assert.Equal(t, time.Millisecond, latencies.Percentile(0.95, hedgeDelay))
+}
+
+func TestLatencyRecorderKeepsAtLeastOneSample(t *testing.T) {
+	latencies := NewLatencyRecorder(0)
+	latencies.Observe(time.Millisecond)
+	latencies.Observe(2 * time.Millisecond)
+	assert.Equal(t, 2*time.Millisecond, latencies.Percentile(0.5, hedgeDelay))
+}
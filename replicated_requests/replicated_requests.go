@@ -24,7 +24,8 @@ import (
 // and coalesce their results later, as here we only care about the first result, always
 
 // DoWork processes a request with a given id with a random delay. This is equivalent to a
-// handler. The calling code will spawn multiple instances of the handler
+// handler. The calling code will spawn multiple instances of the handler. FirstOf does the
+// same wiring for any replica, along with its errors
 func DoWork(ctx context.Context, id int, wg *sync.WaitGroup, result chan<- int) {
 	started := time.Now()
 	defer wg.Done()
@@ -0,0 +1,161 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"patterns/clock"
+	ec "patterns/error_classification"
+	"time"
+)
+
+// Zen: Retrying right away is how a blip becomes an outage: every client hammers the struggling
+// server in lockstep, and it never gets the breathing room to recover. Waiting longer after every
+// failure backs off the load, and randomising the wait spreads clients apart. Retries must also
+// know when to stop, either after a number of attempts or once too much time has passed, and
+// must not retry what will never succeed, which the classification of the error tells.
+
+// DefaultMaxAttempts is the number of attempts made when a policy sets no limit at all
+const DefaultMaxAttempts = 3
+
+// DefaultBaseDelay and DefaultMaxDelay make the Exponential backoff of a policy that sets none
+const (
+	DefaultBaseDelay = 100 * time.Millisecond
+	DefaultMaxDelay  = 10 * time.Second
+)
+
+// Backoff returns how long to wait before the given retry, the first retry being 1. It is given
+// the previous wait, zero before the first retry, for backoffs that build on it.
+type Backoff func(retry int, prev time.Duration) time.Duration
+
+// Constant waits the same delay before every retry
+func Constant(delay time.Duration) Backoff {
+	return func(int, time.Duration) time.Duration {
+		return delay
+	}
+}
+
+// Linear waits one more step before every retry, up to max
+func Linear(step, max time.Duration) Backoff {
+	return func(retry int, _ time.Duration) time.Duration {
+		return capped(time.Duration(retry)*step, max)
+	}
+}
+
+// Exponential doubles the wait before every retry starting from base, up to max
+func Exponential(base, max time.Duration) Backoff {
+	return func(retry int, _ time.Duration) time.Duration {
+		d := float64(base) * math.Pow(2, float64(retry-1))
+		if d >= math.MaxInt64 {
+			return capped(math.MaxInt64, max)
+		}
+		return capped(time.Duration(d), max)
+	}
+}
+
+// DecorrelatedJitter waits a random delay between base and three times the previous wait, up
+// to max. It grows about as fast as Exponential while keeping clients that failed together from
+// retrying together.
+func DecorrelatedJitter(base, max time.Duration) Backoff {
+	return func(_ int, prev time.Duration) time.Duration {
+		if prev < base {
+			prev = base
+		}
+		upper := 3 * prev
+		if upper <= base {
+			return capped(base, max)
+		}
+		return capped(base+time.Duration(rand.Int63n(int64(upper-base))), max)
+	}
+}
+
+// capped bounds d to max, if there is one, and a d that overflowed to the longest wait there is
+func capped(d, max time.Duration) time.Duration {
+	if d < 0 {
+		d = math.MaxInt64
+	}
+	if max > 0 && d > max {
+		return max
+	}
+	return d
+}
+
+// Policy decides whether, and when, a failed call is retried
+type Policy struct {
+	// Backoff is the wait before every retry, defaults to Exponential from DefaultBaseDelay up to
+	// DefaultMaxDelay. Retrying right away takes asking for it, with Constant(0).
+	Backoff Backoff
+	// MaxAttempts caps the number of calls, first one included
+	MaxAttempts int
+	// MaxElapsed caps the time spent, a retry that would start past it isn't made
+	MaxElapsed time.Duration
+	// Retryable decides which errors are retried, defaults to error_classification.IsRetryable
+	Retryable func(error) bool
+	// RespectRetryAfter waits at least as long as the hint carried by an error, if any, before
+	// the retry that follows it. Later retries go back to the backoff.
+	RespectRetryAfter bool
+	// Clock is the source of time for the waits, defaults to the wall clock
+	Clock clock.Clock
+}
+
+// Retry calls fn until it succeeds, fails with an error that isn't retryable, or the policy
+// gives up, in which case the last error is returned. If ctx is done while waiting to retry,
+// an error wrapping both the error of ctx and the last error is returned.
+func Retry(ctx context.Context, fn func(ctx context.Context) error, policy Policy) error {
+	_, err := Do(ctx, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, fn(ctx)
+	}, policy)
+	return err
+}
+
+// Do is Retry for calls that return a value. When it fails, it returns the value of the last call.
+func Do[T any](ctx context.Context, fn func(ctx context.Context) (T, error), policy Policy) (T, error) {
+	clk := policy.Clock
+	if clk == nil {
+		clk = clock.New()
+	}
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = ec.IsRetryable
+	}
+	backoff := policy.Backoff
+	if backoff == nil {
+		backoff = Exponential(DefaultBaseDelay, DefaultMaxDelay)
+	}
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts <= 0 && policy.MaxElapsed <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	start := clk.Now()
+	// the previous backoff, which a hint never carries over to
+	var prev time.Duration
+	for attempt := 1; ; attempt++ {
+		v, err := fn(ctx)
+		if err == nil || !retryable(err) || attempt == maxAttempts {
+			return v, err
+		}
+		prev = backoff(attempt, prev)
+		wait := prev
+		if after, ok := ec.RetryAfter(err); ok && policy.RespectRetryAfter && after > wait {
+			wait = after
+		}
+		if policy.MaxElapsed > 0 && wait > policy.MaxElapsed-clk.Since(start) {
+			return v, err
+		}
+		if wait <= 0 {
+			if ctx.Err() != nil {
+				return v, fmt.Errorf("%w: %w", ctx.Err(), err)
+			}
+			continue
+		}
+		timer := clk.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return v, fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-timer.C():
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"math"
+	"patterns/clock"
+	ec "patterns/error_classification"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+)
+
+var (
+	errTransient = ec.Tag(errors.New("transient"), ec.Transient)
+	errPermanent = ec.Tag(errors.New("permanent"), ec.Permanent)
+)
+
+// failing fails with the given errors in turn, then succeeds with the number of calls
+func failing(errs ...error) (func(context.Context) (int, error), *int) {
+	calls := 0
+	return func(context.Context) (int, error) {
+		calls++
+		if calls <= len(errs) {
+			return calls, errs[calls-1]
+		}
+		return calls, nil
+	}, &calls
+}
+
+func TestBackoffs(t *testing.T) {
+	waits := func(b Backoff) []time.Duration {
+		var out []time.Duration
+		var prev time.Duration
+		for retry := 1; retry <= 5; retry++ {
+			prev = b(retry, prev)
+			out = append(out, prev)
+		}
+		return out
+	}
+	ms := time.Millisecond
+	assert.Equal(t, []time.Duration{5 * ms, 5 * ms, 5 * ms, 5 * ms, 5 * ms}, waits(Constant(5*ms)))
+	assert.Equal(t, []time.Duration{10 * ms, 20 * ms, 30 * ms, 35 * ms, 35 * ms}, waits(Linear(10*ms, 35*ms)))
+	assert.Equal(t, []time.Duration{10 * ms, 20 * ms, 40 * ms, 80 * ms, 100 * ms}, waits(Exponential(10*ms, 100*ms)))
+	assert.Equal(t, 100*ms, Exponential(10*ms, 100*ms)(100, 0))
+	assert.Equal(t, time.Duration(math.MaxInt64), Exponential(time.Hour, 0)(100, 0))
+
+	prev := time.Duration(0)
+	for retry := 1; retry <= 20; retry++ {
+		next := DecorrelatedJitter(10*ms, time.Second)(retry, prev)
+		assert.GreaterOrEqual(t, next, 10*ms)
+		assert.LessOrEqual(t, next, time.Second)
+		if prev >= 10*ms {
+			assert.Less(t, next, 3*prev)
+		}
+		prev = next
+	}
+}
+
+func TestRetryUntilSuccess(t *testing.T) {
+	fn, calls := failing(errTransient, errTransient)
+	v, err := Do(context.Background(), fn, Policy{Backoff: Constant(0), MaxAttempts: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, v)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	fn, calls := failing(errTransient, errTransient, errTransient, errTransient)
+	_, err := Do(context.Background(), fn, Policy{Backoff: Constant(0)})
+	assert.ErrorIs(t, err, errTransient)
+	assert.Equal(t, DefaultMaxAttempts, *calls)
+}
+
+func TestRetryBacksOffByDefault(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	fn, calls := failing(errTransient, errTransient)
+	finished := make(chan error)
+	go func() {
+		_, err := Do(context.Background(), fn, Policy{Clock: clk})
+		finished <- err
+	}()
+
+	clk.BlockUntil(1)
+	assert.Equal(t, 1, *calls)
+	clk.Advance(DefaultBaseDelay)
+	clk.BlockUntil(1)
+	assert.Equal(t, 2, *calls)
+	clk.Advance(2 * DefaultBaseDelay)
+	assert.NoError(t, <-finished)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestRetryDoesNotRetryPermanentErrors(t *testing.T) {
+	fn, calls := failing(errTransient, errPermanent)
+	_, err := Do(context.Background(), fn, Policy{Backoff: Constant(0), MaxAttempts: 5})
+	assert.ErrorIs(t, err, errPermanent)
+	assert.Equal(t, 2, *calls)
+
+	// unless the predicate says otherwise
+	fn, calls = failing(errPermanent)
+	_, err = Do(context.Background(), fn, Policy{Backoff: Constant(0), MaxAttempts: 5, Retryable: func(error) bool { return true }})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestRetryWaitsOnTheClock(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	fn, calls := failing(errTransient, errTransient)
+	finished := make(chan error)
+	go func() {
+		finished <- Retry(context.Background(), func(ctx context.Context) error {
+			_, err := fn(ctx)
+			return err
+		}, Policy{Backoff: Exponential(time.Second, 0), MaxAttempts: 5, Clock: clk})
+	}()
+
+	clk.BlockUntil(1)
+	assert.Equal(t, 1, *calls)
+	clk.Advance(time.Second)
+	clk.BlockUntil(1)
+	assert.Equal(t, 2, *calls)
+	clk.Advance(time.Second)
+	// the second wait is twice as long
+	clk.BlockUntil(1)
+	assert.Equal(t, 2, *calls)
+	clk.Advance(time.Second)
+	assert.NoError(t, <-finished)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestRetryGivesUpAfterMaxElapsed(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	fn, calls := failing(errTransient, errTransient, errTransient)
+	finished := make(chan error)
+	go func() {
+		_, err := Do(context.Background(), fn, Policy{Backoff: Constant(time.Second), MaxElapsed: 1500 * time.Millisecond, Clock: clk})
+		finished <- err
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	// the next retry would start past the budget, so there is none
+	assert.ErrorIs(t, <-finished, errTransient)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestRetryRespectsRetryAfter(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	fn, calls := failing(ec.WithRetryAfter(errors.New("slow down"), time.Minute))
+	finished := make(chan error)
+	go func() {
+		_, err := Do(context.Background(), fn, Policy{Backoff: Constant(time.Second), MaxAttempts: 2, RespectRetryAfter: true, Clock: clk})
+		finished <- err
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	clk.BlockUntil(1)
+	assert.Equal(t, 1, *calls)
+	clk.Advance(time.Minute - time.Second)
+	assert.NoError(t, <-finished)
+	assert.Equal(t, 2, *calls)
+}
+
+func TestRetryAfterOnlyDelaysTheNextRetry(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	fn, calls := failing(ec.WithRetryAfter(errTransient, time.Hour), errTransient)
+	finished := make(chan error)
+	go func() {
+		_, err := Do(context.Background(), fn, Policy{MaxAttempts: 3, RespectRetryAfter: true, Clock: clk})
+		finished <- err
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Hour)
+	clk.BlockUntil(1)
+	assert.Equal(t, 2, *calls)
+	// the second error carries no hint, so its retry waits on the backoff alone
+	clk.Advance(2 * DefaultBaseDelay)
+	assert.NoError(t, <-finished)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestRetryCancelledWhileWaiting(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	fn, _ := failing(errTransient)
+	finished := make(chan error)
+	go func() {
+		_, err := Do(ctx, fn, Policy{Backoff: Constant(time.Second), MaxAttempts: 2, Clock: clk})
+		finished <- err
+	}()
+
+	clk.BlockUntil(1)
+	cancel()
+	err := <-finished
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorIs(t, err, errTransient)
+}
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
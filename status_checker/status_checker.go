@@ -0,0 +1,187 @@
+package status_checker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	ec "patterns/error_classification"
+	eh "patterns/error_handling"
+	"patterns/retry"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Zen: Checking a handful of urls one after the other is fine for a demo, but a smoke test after
+// a deploy checks many of them and can't afford to hang on one. Each url is checked with its own
+// timeout by a bounded number of goroutines, failures that may go away by themselves are retried,
+// and every response body is drained and closed so that its connection goes back to the pool.
+// Results flow back as values, for the caller to decide what a failed deploy looks like.
+
+const (
+	// DefaultConcurrency is the number of urls checked at once when none is configured
+	DefaultConcurrency = 4
+	// DefaultTimeout is the time given to a single request when none is configured
+	DefaultTimeout = 10 * time.Second
+	// maxDrain bounds how much of a body is read to reuse its connection, past it we just close
+	maxDrain = 64 << 10
+)
+
+// Options configure a check
+type Options struct {
+	// Concurrency is the number of urls checked at once
+	Concurrency int
+	// Timeout is the time given to each attempt at a url
+	Timeout time.Duration
+	// Retry decides which failures are tried again and when, the zero policy retrying retryable
+	// failures up to retry.DefaultMaxAttempts attempts, backing off exponentially
+	Retry retry.Policy
+	// Client sends the requests, defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// Status is what a url replied with
+type Status struct {
+	// Code is the status code of the last reply, zero if there was none
+	Code int
+	// Attempts is the number of requests sent for the url
+	Attempts int
+	// Latency is the time the last attempt took
+	Latency time.Duration
+}
+
+// StatusError is returned for a reply with an error status. It is classified by its status code.
+type StatusError struct {
+	URL        string
+	Code       int
+	retryAfter time.Duration
+}
+
+func (err StatusError) Error() string {
+	return fmt.Sprintf("GET %v: %d %v", err.URL, err.Code, http.StatusText(err.Code))
+}
+
+// Class returns the class of the status code
+func (err StatusError) Class() ec.Class {
+	return ec.FromStatus(err.Code)
+}
+
+// RetryAfter returns the hint of the Retry-After header, zero if there was none
+func (err StatusError) RetryAfter() time.Duration {
+	return err.retryAfter
+}
+
+// Check checks every url and returns their results in the order of the urls. The urls left
+// unchecked once ctx is done fail with the error of ctx.
+func Check(ctx context.Context, opts Options, urls ...string) []eh.Result[Status] {
+	results := make([]eh.Result[Status], len(urls))
+	run(ctx, opts, urls, func(i int, r eh.Result[Status]) {
+		results[i] = r
+	})
+	for i, url := range urls {
+		if results[i].Meta == nil {
+			results[i] = eh.Result[Status]{Err: ctx.Err(), Meta: eh.Meta{"url": url, "attempts": "0"}}
+		}
+	}
+	return results
+}
+
+// Stream checks every url and emits their results as they complete. The stream is closed once
+// every url has been checked, or once ctx is done and the checks in flight have given up.
+func Stream(ctx context.Context, opts Options, urls ...string) <-chan eh.Result[Status] {
+	results := make(chan eh.Result[Status])
+	go func() {
+		defer close(results)
+		run(ctx, opts, urls, func(_ int, r eh.Result[Status]) {
+			select {
+			case <-ctx.Done():
+			case results <- r:
+			}
+		})
+	}()
+	return results
+}
+
+// run checks every url with no more than Concurrency checks at once, and hands each result to
+// emit from the goroutine that checked it. It returns once every check started has returned.
+func run(ctx context.Context, opts Options, urls []string, emit func(int, eh.Result[Status])) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	// the barrier lets no more than Concurrency checks run at any given instant
+	barrier := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i, url := range urls {
+		select {
+		case <-ctx.Done():
+			return
+		case barrier <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			defer func() { <-barrier }()
+			status, err := check(ctx, opts, url)
+			meta := eh.Meta{"url": url, "attempts": strconv.Itoa(status.Attempts)}
+			emit(i, eh.Result[Status]{Value: status, Err: err, Meta: meta})
+		}(i, url)
+	}
+}
+
+// check requests url until it succeeds or the retry policy gives up
+func check(ctx context.Context, opts Options, url string) (Status, error) {
+	var status Status
+	err := retry.Retry(ctx, func(ctx context.Context) error {
+		status.Attempts++
+		var err error
+		status.Code, status.Latency, err = get(ctx, opts, url)
+		return err
+	}, opts.Retry)
+	return status, err
+}
+
+// get sends a single request, and drains and closes its body whatever the status
+func get(ctx context.Context, opts Options, url string) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, 0, ec.Tag(err, ec.Permanent)
+	}
+	start := time.Now()
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		// a request that timed out unwraps to context.DeadlineExceeded, and is classified as such
+		return 0, time.Since(start), err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrain))
+	_ = resp.Body.Close()
+	latency := time.Since(start)
+	if resp.StatusCode >= 400 {
+		return resp.StatusCode, latency, StatusError{URL: url, Code: resp.StatusCode, retryAfter: retryAfter(resp)}
+	}
+	return resp.StatusCode, latency, nil
+}
+
+// retryAfter parses the Retry-After header, which holds either seconds or a date
+func retryAfter(resp *http.Response) time.Duration {
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
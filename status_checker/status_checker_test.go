@@ -0,0 +1,187 @@
+package status_checker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	ec "patterns/error_classification"
+	"patterns/retry"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"go.uber.org/goleak"
+)
+
+// server replies to /ok with 200, to /missing with 404, to /flaky with 503 until it has been
+// hit failures times, to /dropped by closing the connection until it has been hit failures
+// times, and to /slow only once the request gives up
+type server struct {
+	*httptest.Server
+	failures    int64
+	flakyHits   atomic.Int64
+	droppedHits atomic.Int64
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+	conns       atomic.Int64
+}
+
+func newServer(t *testing.T, failures int64) *server {
+	s := &server{failures: failures}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.handle))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.conns.Inc()
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) {
+	n := s.inFlight.Inc()
+	defer s.inFlight.Dec()
+	for max := s.maxInFlight.Load(); n > max && !s.maxInFlight.CAS(max, n); max = s.maxInFlight.Load() {
+	}
+	switch r.URL.Path {
+	case "/ok":
+		time.Sleep(5 * time.Millisecond)
+		// a body big enough not to be read by the client on its own
+		_, _ = w.Write([]byte(strings.Repeat("ok", 4<<10)))
+	case "/missing":
+		w.WriteHeader(http.StatusNotFound)
+	case "/flaky":
+		if s.flakyHits.Inc() <= s.failures {
+			w.Header().Set("Retry-After", "3")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	case "/dropped":
+		if s.droppedHits.Inc() <= s.failures {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				_ = conn.Close()
+			}
+		}
+	case "/slow":
+		<-r.Context().Done()
+	}
+}
+
+// client is dedicated to a test, so that its idle connections can be closed when it's over
+func client(t *testing.T) *http.Client {
+	c := &http.Client{Transport: &http.Transport{}}
+	t.Cleanup(c.CloseIdleConnections)
+	return c
+}
+
+func TestCheck(t *testing.T) {
+	s := newServer(t, 1)
+	results := Check(context.Background(), Options{Retry: retry.Policy{MaxAttempts: 3}, Client: client(t)},
+		s.URL+"/ok", s.URL+"/missing", s.URL+"/flaky")
+
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, http.StatusOK, results[0].Value.Code)
+	assert.Equal(t, s.URL+"/ok", results[0].Meta["url"])
+
+	// a permanent failure is not retried
+	assert.EqualError(t, results[1].Err, "GET "+s.URL+"/missing: 404 Not Found")
+	assert.Equal(t, ec.Permanent, ec.Classify(results[1].Err))
+	assert.Equal(t, 1, results[1].Value.Attempts)
+
+	// a transient one is, until it goes away
+	assert.NoError(t, results[2].Err)
+	assert.Equal(t, 2, results[2].Value.Attempts)
+	assert.Equal(t, "2", results[2].Meta["attempts"])
+}
+
+func TestCheckRunsOutOfRetries(t *testing.T) {
+	s := newServer(t, 10)
+	results := Check(context.Background(), Options{Retry: retry.Policy{MaxAttempts: 3}, Client: client(t)}, s.URL+"/flaky")
+
+	assert.Equal(t, 3, results[0].Value.Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, results[0].Value.Code)
+	assert.Equal(t, ec.Transient, ec.Classify(results[0].Err))
+	after, ok := ec.RetryAfter(results[0].Err)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, after)
+}
+
+func TestCheckRetriesDroppedConnections(t *testing.T) {
+	s := newServer(t, 2)
+	results := Check(context.Background(), Options{Retry: retry.Policy{MaxAttempts: 3, Backoff: retry.Constant(0)}, Client: client(t)},
+		s.URL+"/dropped")
+
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, http.StatusOK, results[0].Value.Code)
+	assert.Equal(t, 3, results[0].Value.Attempts)
+}
+
+func TestCheckRetriesRefusedConnections(t *testing.T) {
+	s := newServer(t, 0)
+	url := s.URL + "/ok"
+	s.Close()
+	results := Check(context.Background(), Options{Retry: retry.Policy{MaxAttempts: 2, Backoff: retry.Constant(0)}, Client: client(t)}, url)
+
+	assert.Error(t, results[0].Err)
+	assert.Equal(t, ec.Transient, ec.Classify(results[0].Err))
+	assert.Equal(t, 2, results[0].Value.Attempts)
+}
+
+func TestCheckTimesOutEachRequest(t *testing.T) {
+	s := newServer(t, 0)
+	start := time.Now()
+	results := Check(context.Background(), Options{Timeout: 20 * time.Millisecond, Retry: retry.Policy{MaxAttempts: 2}, Client: client(t)},
+		s.URL+"/slow", s.URL+"/ok")
+
+	assert.ErrorIs(t, results[0].Err, context.DeadlineExceeded)
+	assert.Equal(t, ec.Timeout, ec.Classify(results[0].Err))
+	assert.Equal(t, 2, results[0].Value.Attempts)
+	assert.NoError(t, results[1].Err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestCheckBoundsConcurrency(t *testing.T) {
+	s := newServer(t, 0)
+	urls := make([]string, 12)
+	for i := range urls {
+		urls[i] = s.URL + "/ok"
+	}
+	results := Check(context.Background(), Options{Concurrency: 3, Client: client(t)}, urls...)
+
+	for _, r := range results {
+		assert.NoError(t, r.Err)
+	}
+	assert.LessOrEqual(t, s.maxInFlight.Load(), int64(3))
+}
+
+func TestCheckDrainsBodies(t *testing.T) {
+	s := newServer(t, 0)
+	// with one check at a time, a connection is only reused if the body before was drained
+	Check(context.Background(), Options{Concurrency: 1, Client: client(t)},
+		s.URL+"/ok", s.URL+"/missing", s.URL+"/ok", s.URL+"/ok")
+	assert.Equal(t, int64(1), s.conns.Load())
+}
+
+func TestStreamCancelled(t *testing.T) {
+	s := newServer(t, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	results := Stream(ctx, Options{Concurrency: 1, Client: client(t)}, s.URL+"/slow", s.URL+"/ok")
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	for r := range results {
+		assert.ErrorIs(t, r.Err, context.Canceled)
+	}
+
+	unchecked := Check(ctx, Options{Client: client(t)}, s.URL+"/ok")
+	assert.ErrorIs(t, unchecked[0].Err, context.Canceled)
+}
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}